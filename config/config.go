@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"io/fs"
 	"os"
 
 	"github.com/joho/godotenv"
@@ -10,7 +11,8 @@ import (
 func init() {
 	err := godotenv.Load()
 
-	if err != nil {
+	// without a .env file the variables come from the environment, e.g. in tests
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		panic(errors.Join(err, errors.New("failed to load env")))
 	}
 }
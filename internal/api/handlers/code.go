@@ -3,6 +3,7 @@ package handlers
 import (
 	"code-garden-server/internal/database"
 	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
 	"code-garden-server/internal/services/auth"
 	"code-garden-server/internal/services/docker"
 	"code-garden-server/utils"
@@ -14,7 +15,9 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"sort"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
@@ -135,10 +138,22 @@ func (*CodeHandler) RunCodeUnsafe(w http.ResponseWriter, r *http.Request) {
 func (c *CodeHandler) CreateCodeSnippet(w http.ResponseWriter, r *http.Request) {
 
 	type createCodeRequestBody struct {
-		Code     string `json:"code"`
-		Language string `json:"language"`
-		Output   string `json:"output"`
-		Name     string `json:"name"`
+		Code     string   `json:"code"`
+		Language string   `json:"language"`
+		Output   string   `json:"output"`
+		Name     string   `json:"name"`
+		Stdin    string   `json:"stdin"`
+		Args     []string `json:"args"`
+		// Files and Entrypoint make a multi-file snippet instead of Code
+		Files      map[string]string `json:"files"`
+		Entrypoint string            `json:"entrypoint"`
+		Tags       []string          `json:"tags"`
+		Visibility string            `json:"visibility"`
+		// Password protects snippets with the password visibility
+		Password *string `json:"password"`
+		// Organization is the slug of the organization the snippet belongs to,
+		// the user has to be at least one of its editors
+		Organization string `json:"organization"`
 	}
 
 	var body createCodeRequestBody
@@ -153,17 +168,50 @@ func (c *CodeHandler) CreateCodeSnippet(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if _, ok := docker.LanguageToDockerFileMap[docker.Language(body.Language)]; !ok {
+	if _, ok := docker.GetLanguage(docker.Language(body.Language)); !ok {
 		utils.WriteRes(w, utils.Response{Data: nil, Message: "Unsupported Language", Status: http.StatusBadRequest, Error: "unsupported language"})
 		return
 	}
 
+	files, err := newSnippetFiles(body.Language, body.Files, body.Entrypoint)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Data: nil, Message: "Bad request", Status: http.StatusBadRequest, Error: err.Error()})
+		return
+	}
+
+	tags, err := normalizeTags(body.Tags)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Data: nil, Message: "Bad request", Status: http.StatusBadRequest, Error: err.Error()})
+		return
+	}
+
 	user := auth.GetUser(r)
 
-	snippet := models.Snippet{Code: body.Code, Language: body.Language, Output: body.Output, Name: body.Name, OwnerId: user.ID}
-	tx := c.DbClient.Create(&snippet)
-	if tx.Error != nil {
-		utils.WriteRes(w, utils.Response{Data: nil, Message: "Failed to create snipped", Status: http.StatusInternalServerError, Error: tx.Error.Error()})
+	snippet := models.Snippet{Code: body.Code, Language: body.Language, Output: body.Output, Name: body.Name, OwnerId: user.ID, Stdin: body.Stdin, Args: body.Args, Files: files, Entrypoint: body.Entrypoint, Visibility: models.VisibilityPrivate, Role: models.RoleOwner}
+	if body.Organization != "" {
+		organization, err := queries.GetOrganizationWithRole(body.Organization, user.ID, models.RoleEditor, c.DbClient)
+		if err != nil {
+			writeOrganizationLookupError(w, body.Organization, err)
+			return
+		}
+		snippet.OrganizationId, snippet.Role = &organization.ID, organization.Role
+	}
+	if err := setVisibility(&snippet, models.Visibility(body.Visibility), body.Password); err != nil {
+		utils.WriteRes(w, utils.Response{Data: nil, Message: "Bad request", Status: http.StatusBadRequest, Error: err.Error()})
+		return
+	}
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&snippet).Error; err != nil {
+			return err
+		}
+		if err := setSnippetTags(tx, &snippet, tags); err != nil {
+			return err
+		}
+		_, err := recordRevision(tx, &snippet, user.ID, "Created snippet")
+		return err
+	})
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Data: nil, Message: "Failed to create snipped", Status: http.StatusInternalServerError, Error: err.Error()})
 		return
 	}
 
@@ -177,6 +225,22 @@ func (c *CodeHandler) UpdateSnippet(w http.ResponseWriter, r *http.Request) {
 		Output     string `json:"output"`
 		Name       string `json:"name"`
 		Visibility string `json:"visibility"`
+		// Password sets the password of password protected snippets
+		Password *string `json:"password"`
+		// Stdin and Args are pointers so they can be cleared with an empty value
+		Stdin *string   `json:"stdin"`
+		Args  *[]string `json:"args"`
+		// Files replaces every file of the snippet, an empty object removes them
+		Files      *map[string]string `json:"files"`
+		Entrypoint *string            `json:"entrypoint"`
+		// Tags replaces every tag of the snippet
+		Tags *[]string `json:"tags"`
+		// Message describes the revision created when the program changes
+		Message string `json:"message"`
+		// Organization moves the snippet to the organization with that slug, which
+		// takes admin rights in both organizations. An empty one makes it a
+		// personal snippet of the user again, which only organization owners can do.
+		Organization *string `json:"organization"`
 	}
 
 	publicId := r.PathValue("publicId")
@@ -193,7 +257,7 @@ func (c *CodeHandler) UpdateSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, ok := docker.LanguageToDockerFileMap[docker.Language(body.Language)]; !ok {
+	if _, ok := docker.GetLanguage(docker.Language(body.Language)); body.Language != "" && !ok {
 		utils.WriteRes(w, utils.Response{Data: nil, Message: "Unsupported Language", Status: http.StatusBadRequest, Error: "unsupported language"})
 		return
 	}
@@ -212,18 +276,153 @@ func (c *CodeHandler) UpdateSnippet(w http.ResponseWriter, r *http.Request) {
 	if body.Name != "" {
 		updates["name"] = body.Name
 	}
-	if body.Visibility != "" {
-		updates["visibility"] = body.Visibility
+	if body.Stdin != nil {
+		updates["stdin"] = *body.Stdin
+	}
+	if body.Args != nil {
+		// map updates skip gorm serializers, so encode the same way the json serializer does
+		args, err := json.Marshal(*body.Args)
+		if err != nil {
+			utils.WriteRes(w, utils.Response{Data: nil, Message: "Bad request", Status: http.StatusBadRequest, Error: err.Error()})
+			return
+		}
+		updates["args"] = string(args)
+	}
+	if body.Entrypoint != nil {
+		updates["entrypoint"] = *body.Entrypoint
 	}
 
-	var snippet models.Snippet
+	var tags []models.Tag
+	if body.Tags != nil {
+		if tags, err = normalizeTags(*body.Tags); err != nil {
+			utils.WriteRes(w, utils.Response{Data: nil, Message: "Bad request", Status: http.StatusBadRequest, Error: err.Error()})
+			return
+		}
+	}
+
+	// only changes to the program itself are kept as revisions
+	programChanged := body.Code != "" || body.Language != "" || body.Files != nil || body.Entrypoint != nil
+
 	var user = auth.GetUser(r)
-	tx := c.DbClient.Model(&snippet).Where("public_id = ? and owner_id = ?", publicId, user.ID).Updates(updates)
-	if tx.Error != nil {
-		utils.WriteRes(w, utils.Response{Data: nil, Message: "Failed to update snippet", Status: http.StatusInternalServerError, Error: tx.Error.Error()})
+	editable, err := queries.GetEditableSnippet(publicId, user.ID, c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+	// who can see the snippet and who it belongs to is up to its admins
+	if (body.Visibility != "" || body.Password != nil || body.Organization != nil) && !editable.Role.AtLeast(models.RoleAdmin) {
+		writeSnippetLookupError(w, publicId, models.ErrInsufficientRole)
+		return
+	}
+	role := editable.Role
+	if body.Organization != nil {
+		if *body.Organization == "" {
+			// taking a snippet away from its organization is up to the organization's owners
+			if editable.OrganizationId != nil && !editable.Role.AtLeast(models.RoleOwner) {
+				writeSnippetLookupError(w, publicId, models.ErrInsufficientRole)
+				return
+			}
+			updates["organization_id"], updates["owner_id"] = nil, user.ID
+			role = models.RoleOwner
+		} else {
+			// moving takes admin rights where the snippet is, checked above, and where it goes
+			organization, err := queries.GetOrganizationWithRole(*body.Organization, user.ID, models.RoleAdmin, c.DbClient)
+			if err != nil {
+				writeOrganizationLookupError(w, *body.Organization, err)
+				return
+			}
+			updates["organization_id"], role = organization.ID, organization.Role
+		}
+	}
+
+	var snippet models.Snippet
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("Files").Preload("Tags").First(&snippet, "id = ?", editable.ID).Error; err != nil {
+			return err
+		}
+
+		if body.Organization != nil {
+			// a transfer to someone else makes no sense once the snippet changed hands
+			if err := cancelPendingTransfers(tx, snippet.ID); err != nil {
+				return err
+			}
+		}
+
+		if body.Visibility != "" || body.Password != nil {
+			if err := setVisibility(&snippet, models.Visibility(body.Visibility), body.Password); err != nil {
+				return err
+			}
+			updates["visibility"] = snippet.Visibility
+			updates["password_hash"] = snippet.PasswordHash
+		}
+
+		// snippets created before revisions existed keep their current program as the first one
+		if programChanged && snippet.Revision == 0 {
+			if _, err := recordRevision(tx, &snippet, snippet.OwnerId, "Initial revision"); err != nil {
+				return err
+			}
+		}
+
+		// the files, entrypoint and language have to keep working together
+		if body.Files != nil || body.Entrypoint != nil || (body.Language != "" && len(snippet.Files) > 0) {
+			files := snippet.FileMap()
+			if body.Files != nil {
+				files = *body.Files
+			}
+			entrypoint := snippet.Entrypoint
+			if body.Entrypoint != nil {
+				entrypoint = *body.Entrypoint
+			}
+			language := snippet.Language
+			if body.Language != "" {
+				language = body.Language
+			}
+
+			newFiles, err := newSnippetFiles(language, files, entrypoint)
+			if err != nil {
+				return err
+			}
+			if body.Files != nil {
+				if err := replaceSnippetFiles(tx, &snippet, newFiles); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(updates) > 0 {
+			if err := tx.Model(&snippet).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if body.Tags != nil {
+			if err := setSnippetTags(tx, &snippet, tags); err != nil {
+				return err
+			}
+		}
+		if !programChanged {
+			return nil
+		}
+
+		if err := tx.Preload("Files").Preload("Tags").First(&snippet, "id = ?", snippet.ID).Error; err != nil {
+			return err
+		}
+		_, err := recordRevision(tx, &snippet, user.ID, body.Message)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.WriteRes(w, utils.Response{Data: nil, Message: "snippet not found", Status: http.StatusNotFound, Error: err.Error()})
+			return
+		}
+		if errors.Is(err, docker.ErrInvalidRunRequest) || errors.Is(err, errInvalidVisibility) {
+			utils.WriteRes(w, utils.Response{Data: nil, Message: "Bad request", Status: http.StatusBadRequest, Error: err.Error()})
+			return
+		}
+		utils.WriteRes(w, utils.Response{Data: nil, Message: "Failed to update snippet", Status: http.StatusInternalServerError, Error: err.Error()})
 		return
 	}
 
+	snippet.Role = role
 	utils.WriteRes(w, utils.Response{Data: snippet, Message: "Snippet updated successfully", Status: http.StatusOK, Error: ""})
 }
 
@@ -242,26 +441,40 @@ func (c *CodeHandler) GetSnippet(w http.ResponseWriter, r *http.Request) {
 
 	user := auth.GetUser(r)
 
-	s := new(models.Snippet)
+	s, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	if tx := c.DbClient.Model(s).Preload("Owner").Preload("Organization").Preload("Files").Preload("Tags").First(s, "id = ?", s.ID); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{
+			Error:   tx.Error.Error(),
+			Data:    nil,
+			Status:  http.StatusInternalServerError,
+			Message: "An error occurred",
+		})
+		return
+	}
 
-	if tx := c.DbClient.Model(s).Preload("Owner").First(s, "public_id = ? AND (owner_id = ? or visibility = 'public')", publicId, user.ID.String()); tx.Error != nil {
-		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-			utils.WriteRes(w, utils.Response{
-				Error:   tx.Error.Error(),
-				Data:    nil,
-				Status:  http.StatusNotFound,
-				Message: fmt.Sprintf("Snippet with ID %s not found", publicId),
-			})
-		} else {
-			utils.WriteRes(w, utils.Response{
-				Error:   tx.Error.Error(),
-				Data:    nil,
-				Status:  http.StatusInternalServerError,
-				Message: "An error occurred",
-			})
-		}
+	starred, err := queries.IsStarredBy(s.ID, user.ID, c.DbClient)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{
+			Error:   err.Error(),
+			Data:    nil,
+			Status:  http.StatusInternalServerError,
+			Message: "An error occurred",
+		})
 		return
 	}
+	s.StarredByMe = starred
+
+	// only show where the snippet was forked from if the user can see the original
+	if s.ForkedFromId != nil {
+		if parent, err := queries.GetVisibleSnippetById(*s.ForkedFromId, user.ID, c.DbClient); err == nil {
+			s.ForkedFrom = parent
+		}
+	}
 
 	utils.WriteRes(w, utils.Response{
 		Error:   "",
@@ -284,24 +497,20 @@ func (c *CodeHandler) GetSnippetNoAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s := new(models.Snippet)
+	// nobody owns the snippet when signed out, so only snippets shared by link are found
+	s, err := queries.GetVisibleSnippet(publicId, uuid.Nil, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
 
-	if tx := c.DbClient.Model(s).First(s, "public_id = ? AND visibility = 'public'", publicId); tx.Error != nil {
-		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-			utils.WriteRes(w, utils.Response{
-				Error:   tx.Error.Error(),
-				Data:    nil,
-				Status:  http.StatusNotFound,
-				Message: fmt.Sprintf("Snippet with ID %s not found", publicId),
-			})
-		} else {
-			utils.WriteRes(w, utils.Response{
-				Error:   tx.Error.Error(),
-				Data:    nil,
-				Status:  http.StatusInternalServerError,
-				Message: "An error occurred",
-			})
-		}
+	if tx := c.DbClient.Model(s).Preload("Files").Preload("Tags").First(s, "id = ?", s.ID); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{
+			Error:   tx.Error.Error(),
+			Data:    nil,
+			Status:  http.StatusInternalServerError,
+			Message: "An error occurred",
+		})
 		return
 	}
 
@@ -313,59 +522,97 @@ func (c *CodeHandler) GetSnippetNoAuth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// DeleteSnippet moves a snippet to the trash, it can be restored until it's
+// purged. Snippets of an organization are deleted by its admins.
 func (c *CodeHandler) DeleteSnippet(w http.ResponseWriter, r *http.Request) {
 	publicId := r.PathValue("publicId")
 
 	user := auth.GetUser(r)
-	snippet := models.Snippet{}
-	db := c.DbClient.DB.Delete(&snippet, "public_id = ? and owner_id = ?", publicId, user.ID)
+	snippet, err := queries.GetOwnedSnippet(publicId, user.ID, c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	db := c.DbClient.DB.Delete(snippet, "id = ?", snippet.ID)
 	if db.Error != nil {
-		if errors.Is(db.Error, gorm.ErrRecordNotFound) {
-			utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "snippet not found", Error: db.Error.Error()})
-			return
-		}
 		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Unknown error", Error: db.Error.Error()})
+		return
 	}
 
-	fmt.Print(db.RowsAffected)
-
 	if db.RowsAffected == 0 {
 		utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "snippet not found", Error: fmt.Sprintf("snippet with public: %s id not found", publicId)})
 		return
 	}
 
-	utils.WriteRes(w, utils.Response{Status: 200, Message: "Snippet deleted successfully", Data: snippet})
+	utils.WriteRes(w, utils.Response{Status: 200, Message: "Snippet moved to trash", Data: snippet})
 }
 
+// GetUserSnippets returns a page of the user's personal snippets, the ones that
+// belong to an organization are listed with it. The collection query parameter
+// returns the ones in that collection of the user instead, including those of
+// their organizations, in its order unless sorted otherwise.
 func (c *CodeHandler) GetUserSnippets(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
 
-	var snippets []models.Snippet
+	filter, err := snippetFilterFromQuery(r)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	tx := c.DbClient.Model(models.Snippet{})
 
-	tx := c.DbClient.Preload("Owner").Model(models.Snippet{}).Find(&snippets, "owner_id = ?", user.ID)
-	if tx.Error != nil {
-		utils.WriteRes(w, utils.Response{
-			Error:   tx.Error.Error(),
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to retrieve user's snippets",
-		})
+	sorts, defaultSort := queries.SnippetSorts, "recent"
+	if collectionId := r.URL.Query().Get("collection"); collectionId == "" {
+		tx = tx.Where("snippets.owner_id = ? AND snippets.organization_id IS NULL", user.ID)
+	} else {
+		collection, err := queries.GetOwnedCollection(collectionId, user.ID, c.DbClient)
+		if err != nil {
+			writeCollectionLookupError(w, collectionId, err)
+			return
+		}
+		tx = queries.WhereSnippetRole(tx, user.ID, models.RoleViewer).Joins("JOIN collection_snippets ON collection_snippets.snippet_id = snippets.id AND collection_snippets.collection_id = ?", collection.ID)
+		sorts, defaultSort = collectionSorts, "position"
+	}
+
+	params, err := pageParamsFromQuery(r, sorts, defaultSort)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
 		return
 	}
 
-	utils.WriteRes(w, utils.Response{
-		Data: map[string]interface{}{
-			"snippets": snippets,
-			"total":    len(snippets),
-		},
-		Status:  http.StatusOK,
-		Message: "User's snippets retrieved successfully",
-	})
+	// the user owns all of them, so the owner isn't loaded for every snippet
+	page, err := queries.PaginateSnippets(filter.Apply(tx), params, "Tags")
+	if err != nil {
+		writePageError(w, err, "Failed to retrieve user's snippets")
+		return
+	}
+
+	writePage(w, "snippets", page, "User's snippets retrieved successfully")
 }
 
+// ForkSnippet copies a snippet the user can see into a new snippet of theirs,
+// or of the organization in the body if they're at least one of its editors.
 func (c *CodeHandler) ForkSnippet(w http.ResponseWriter, r *http.Request) {
+	type forkRequestBody struct {
+		Organization string `json:"organization"`
+	}
+
 	publicId := r.PathValue("publicId")
 	user := auth.GetUser(r)
 
+	var body forkRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
 	if publicId == "" {
 		utils.WriteRes(w, utils.Response{
 			Error:   "bad request: empty public id",
@@ -376,31 +623,78 @@ func (c *CodeHandler) ForkSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	visible, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
 	snippet := models.Snippet{}
-	db := c.DbClient.DB.First(&snippet, "public_id = ? and visibility = 'public'", publicId)
+	db := c.DbClient.DB.Preload("Files").Preload("Tags").First(&snippet, "id = ?", visible.ID)
 	if db.Error != nil {
-		if errors.Is(db.Error, gorm.ErrRecordNotFound) {
-			utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "snippet not found", Error: db.Error.Error()})
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Unknown error", Error: db.Error.Error()})
+		return
+	}
+
+	var organization *models.Organization
+	if body.Organization != "" {
+		organization, err = queries.GetOrganizationWithRole(body.Organization, user.ID, models.RoleEditor, c.DbClient)
+		if err != nil {
+			writeOrganizationLookupError(w, body.Organization, err)
+			return
 		}
 	}
 
-	if snippet.OwnerId == user.ID {
+	// a snippet can be forked into an organization it doesn't belong to, but not into where it already is
+	sameOrganization := organization != nil && snippet.OrganizationId != nil && *snippet.OrganizationId == organization.ID
+	ownSnippet := organization == nil && snippet.OrganizationId == nil && snippet.OwnerId == user.ID
+	if sameOrganization || ownSnippet {
 		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "cannot fork your own snippet", Error: "cannot fork your own snippet"})
 		return
 	}
 
 	newSnippet := models.Snippet{
-		Code:     snippet.Code,
-		Language: snippet.Language,
-		Output:   snippet.Output,
-		Name:     snippet.Name,
-		OwnerId:  user.ID,
-	}
+		Code:         snippet.Code,
+		Language:     snippet.Language,
+		Output:       snippet.Output,
+		Name:         snippet.Name,
+		OwnerId:      user.ID,
+		Stdin:        snippet.Stdin,
+		Args:         snippet.Args,
+		Entrypoint:   snippet.Entrypoint,
+		ForkedFromId: &snippet.ID,
+		Role:         models.RoleOwner,
+	}
+	if organization != nil {
+		newSnippet.OrganizationId, newSnippet.Role = &organization.ID, organization.Role
+	}
+	for _, f := range snippet.Files {
+		newSnippet.Files = append(newSnippet.Files, models.SnippetFile{Path: f.Path, Content: f.Content})
+	}
+
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		// the fork points at a revision, so snippets created before revisions existed get their first one
+		if snippet.Revision == 0 {
+			if _, err := recordRevision(tx, &snippet, snippet.OwnerId, "Initial revision"); err != nil {
+				return err
+			}
+		}
+		newSnippet.ForkedFromRevision = snippet.Revision
 
-	tx := c.DbClient.Create(&newSnippet)
-	if tx.Error != nil {
+		if err := tx.Create(&newSnippet).Error; err != nil {
+			return err
+		}
+		if err := setSnippetTags(tx, &newSnippet, snippet.Tags); err != nil {
+			return err
+		}
+		if _, err := recordRevision(tx, &newSnippet, user.ID, fmt.Sprintf("Forked from %s", snippet.PublicId)); err != nil {
+			return err
+		}
+		return tx.Model(&models.Snippet{}).Where("id = ?", snippet.ID).UpdateColumn("forks", gorm.Expr("forks + 1")).Error
+	})
+	if err != nil {
 		utils.WriteRes(w, utils.Response{
-			Error:   tx.Error.Error(),
+			Error:   err.Error(),
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to fork snippet",
 		})
@@ -415,3 +709,141 @@ func (c *CodeHandler) ForkSnippet(w http.ResponseWriter, r *http.Request) {
 
 	return
 }
+
+// SnippetPasswordHeader carries the password of password protected snippets.
+const SnippetPasswordHeader = "X-Snippet-Password"
+
+func snippetPassword(r *http.Request) string {
+	return r.Header.Get(SnippetPasswordHeader)
+}
+
+// writeSnippetLookupError responds to a failed snippet lookup, hiding snippets
+// the user can't see behind a 404.
+func writeSnippetLookupError(w http.ResponseWriter, publicId string, err error) {
+	if errors.Is(err, models.ErrSnippetPasswordRequired) {
+		utils.WriteRes(w, utils.Response{
+			Error:   err.Error(),
+			Status:  http.StatusUnauthorized,
+			Message: "Password required",
+		})
+		return
+	}
+	if errors.Is(err, models.ErrWrongSnippetPassword) {
+		utils.WriteRes(w, utils.Response{
+			Error:   err.Error(),
+			Status:  http.StatusForbidden,
+			Message: "Wrong password",
+		})
+		return
+	}
+	if errors.Is(err, models.ErrInsufficientRole) {
+		utils.WriteRes(w, utils.Response{
+			Error:   err.Error(),
+			Status:  http.StatusForbidden,
+			Message: "Forbidden",
+		})
+		return
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.WriteRes(w, utils.Response{
+			Error:   err.Error(),
+			Status:  http.StatusNotFound,
+			Message: fmt.Sprintf("Snippet with ID %s not found", publicId),
+		})
+		return
+	}
+	utils.WriteRes(w, utils.Response{
+		Error:   err.Error(),
+		Status:  http.StatusInternalServerError,
+		Message: "An error occurred",
+	})
+}
+
+var errInvalidVisibility = errors.New("invalid visibility")
+
+// maxSnippetPasswordLength is the most bcrypt can hash.
+const maxSnippetPasswordLength = 72
+
+// setVisibility changes the visibility of the snippet, an empty visibility
+// keeps the current one. Password protected snippets need a password unless
+// they already have one, any other visibility drops the password.
+func setVisibility(snippet *models.Snippet, visibility models.Visibility, password *string) error {
+	if visibility == "" {
+		visibility = snippet.Visibility
+	}
+	if !visibility.IsValid() {
+		return fmt.Errorf("%w: %q", errInvalidVisibility, visibility)
+	}
+	hasPassword := password != nil && *password != ""
+
+	if visibility != models.VisibilityPassword {
+		if hasPassword {
+			return fmt.Errorf("%w: only password protected snippets have a password", errInvalidVisibility)
+		}
+		snippet.Visibility, snippet.PasswordHash = visibility, ""
+		return nil
+	}
+
+	if hasPassword {
+		if len(*password) > maxSnippetPasswordLength {
+			return fmt.Errorf("%w: passwords can't be longer than %d bytes", errInvalidVisibility, maxSnippetPasswordLength)
+		}
+		if err := snippet.SetPassword(*password); err != nil {
+			return err
+		}
+	} else if snippet.PasswordHash == "" {
+		return fmt.Errorf("%w: password protected snippets need a password", errInvalidVisibility)
+	}
+	snippet.Visibility = visibility
+	return nil
+}
+
+// newSnippetFiles validates the files of a multi-file snippet in the given
+// language and turns them into models, ordered by path.
+func newSnippetFiles(language string, files map[string]string, entrypoint string) ([]models.SnippetFile, error) {
+	if len(files) == 0 {
+		if entrypoint != "" {
+			return nil, fmt.Errorf("%w: entrypoint set without files", docker.ErrInvalidRunRequest)
+		}
+		return nil, nil
+	}
+
+	if spec, ok := docker.GetLanguage(docker.Language(language)); !ok || spec.Project == nil {
+		return nil, fmt.Errorf("%w: %s doesn't support multi-file projects", docker.ErrInvalidRunRequest, language)
+	}
+	if err := docker.ValidateProjectFiles(files, entrypoint); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	snippetFiles := make([]models.SnippetFile, 0, len(files))
+	for _, p := range paths {
+		snippetFiles = append(snippetFiles, models.SnippetFile{Path: p, Content: files[p]})
+	}
+	return snippetFiles, nil
+}
+
+// replaceSnippetFiles deletes every file of the snippet and saves files in their place.
+func replaceSnippetFiles(tx *gorm.DB, snippet *models.Snippet, files []models.SnippetFile) error {
+	// hard delete so the paths can be reused under the unique index
+	if err := tx.Unscoped().Where("snippet_id = ?", snippet.ID).Delete(&models.SnippetFile{}).Error; err != nil {
+		return err
+	}
+
+	for i := range files {
+		files[i].SnippetId = snippet.ID
+	}
+	if len(files) > 0 {
+		if err := tx.Create(&files).Error; err != nil {
+			return err
+		}
+	}
+
+	snippet.Files = files
+	return nil
+}
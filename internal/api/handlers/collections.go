@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/internal/services/auth"
+	"code-garden-server/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxCollectionDepth is how deep collections can be nested.
+const MaxCollectionDepth = 10
+
+var errInvalidCollection = errors.New("invalid collection")
+
+// collectionSorts adds the order of a collection to the snippet sorts.
+var collectionSorts = withSnippetSorts(map[string]queries.SortKey{
+	"position": {Expr: "collection_snippets.position", Type: "bigint"},
+})
+
+type collectionRequestBody struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Visibility  *string `json:"visibility"`
+	// Parent is the public id of the parent collection, empty moves the collection to the top level
+	Parent   *string `json:"parent"`
+	Position *int    `json:"position"`
+}
+
+// apply validates the body and copies the fields present in it onto the
+// collection, except for the parent which setCollectionParent takes care of.
+func (b collectionRequestBody) apply(collection *models.Collection) error {
+	if b.Name != nil {
+		name := strings.TrimSpace(*b.Name)
+		if name == "" {
+			return fmt.Errorf("%w: a collection needs a name", errInvalidCollection)
+		}
+		collection.Name = name
+	}
+	if b.Description != nil {
+		collection.Description = *b.Description
+	}
+	if b.Visibility != nil {
+		visibility := models.Visibility(*b.Visibility)
+		if !visibility.IsValid() || visibility == models.VisibilityPassword {
+			return fmt.Errorf("%w: invalid visibility %q", errInvalidCollection, visibility)
+		}
+		collection.Visibility = visibility
+	}
+	if b.Position != nil {
+		collection.Position = *b.Position
+	}
+	return nil
+}
+
+// setCollectionParent moves the collection into one of the user's collections,
+// or to the top level when parentPublicId is empty.
+func setCollectionParent(tx *gorm.DB, collection *models.Collection, parentPublicId string) error {
+	if parentPublicId == "" {
+		collection.ParentId = nil
+		return nil
+	}
+
+	var parent models.Collection
+	if err := tx.First(&parent, "public_id = ? AND owner_id = ?", parentPublicId, collection.OwnerId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: parent collection %s not found", errInvalidCollection, parentPublicId)
+		}
+		return err
+	}
+
+	tooDeep := fmt.Errorf("%w: collections can't be nested more than %d deep", errInvalidCollection, MaxCollectionDepth)
+
+	// walk up from the new parent to make sure the collection doesn't end up
+	// inside itself, counting the levels down to the parent
+	ancestor := &parent
+	parentDepth := 1
+	for {
+		if ancestor.ID == collection.ID {
+			return fmt.Errorf("%w: a collection can't be moved into itself", errInvalidCollection)
+		}
+		if ancestor.ParentId == nil {
+			break
+		}
+		if parentDepth >= MaxCollectionDepth {
+			return tooDeep
+		}
+		next := new(models.Collection)
+		if err := tx.Select("id", "parent_id").First(next, "id = ?", *ancestor.ParentId).Error; err != nil {
+			return err
+		}
+		ancestor = next
+		parentDepth++
+	}
+
+	// the subcollections move along, so the deepest of them has to fit too
+	height, err := collectionHeight(tx, collection, MaxCollectionDepth-parentDepth)
+	if err != nil {
+		return err
+	}
+	if parentDepth+height > MaxCollectionDepth {
+		return tooDeep
+	}
+
+	collection.ParentId = &parent.ID
+	return nil
+}
+
+// collectionHeight returns how many levels the collection and its subcollections
+// span, 1 for a collection without any. It stops counting once it passed max.
+func collectionHeight(tx *gorm.DB, collection *models.Collection, max int) (int, error) {
+	// a collection that is being created has no subcollections yet
+	if collection.ID == uuid.Nil {
+		return 1, nil
+	}
+
+	height := 1
+	level := []uuid.UUID{collection.ID}
+	for height <= max {
+		var children []uuid.UUID
+		if err := tx.Model(&models.Collection{}).Where("parent_id IN ?", level).Pluck("id", &children).Error; err != nil {
+			return 0, err
+		}
+		if len(children) == 0 {
+			break
+		}
+		height++
+		level = children
+	}
+	return height, nil
+}
+
+func writeCollectionLookupError(w http.ResponseWriter, publicId string, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: fmt.Sprintf("Collection with ID %s not found", publicId), Error: err.Error()})
+		return
+	}
+	utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "An error occurred", Error: err.Error()})
+}
+
+func writeCollectionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidCollection) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to save collection", Error: err.Error()})
+}
+
+func (c *CodeHandler) CreateCollection(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	var body collectionRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	if body.Name == nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: "a collection needs a name"})
+		return
+	}
+
+	collection := models.Collection{OwnerId: user.ID, Visibility: models.VisibilityPrivate}
+	if err := body.apply(&collection); err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	err := c.DbClient.Transaction(func(tx *gorm.DB) error {
+		if body.Parent != nil {
+			if err := setCollectionParent(tx, &collection, *body.Parent); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&collection).Error
+	})
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusCreated, Data: collection, Message: "Collection created successfully"})
+}
+
+var userCollectionSorts = map[string]queries.SortKey{
+	"position": {Expr: "collections.position", Type: "bigint"},
+	"name":     {Expr: "collections.name", Type: "text"},
+	"recent":   {Expr: "collections.created_at", Type: "timestamptz", Desc: true},
+}
+
+// GetUserCollections returns a page of the user's top level collections, by
+// position unless sorted otherwise, each with its subcollections as a tree.
+// Subcollections are ordered by position and total counts the top level ones.
+func (c *CodeHandler) GetUserCollections(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	params, err := pageParamsFromQuery(r, userCollectionSorts, "position")
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	tx := c.DbClient.Model(models.Collection{}).Where("collections.owner_id = ? AND collections.parent_id IS NULL", user.ID)
+
+	load := func(ids []uuid.UUID) ([]models.Collection, error) {
+		var roots []models.Collection
+		if err := c.DbClient.Find(&roots, "id IN ?", ids).Error; err != nil {
+			return nil, err
+		}
+
+		// load the subcollections level by level, they can't be nested deeper than MaxCollectionDepth
+		var descendants []models.Collection
+		level := ids
+		for depth := 1; depth < MaxCollectionDepth && len(level) > 0; depth++ {
+			var children []models.Collection
+			if err := c.DbClient.Where("parent_id IN ?", level).Order("position, name").Find(&children).Error; err != nil {
+				return nil, err
+			}
+			descendants = append(descendants, children...)
+
+			level = make([]uuid.UUID, len(children))
+			for i, child := range children {
+				level[i] = child.ID
+			}
+		}
+
+		for i := range roots {
+			roots[i].Children = collectionTree(descendants, &roots[i].ID)
+		}
+		return roots, nil
+	}
+
+	page, err := queries.Paginate(tx, "collections", params, load, func(collection models.Collection) uuid.UUID { return collection.ID })
+	if err != nil {
+		writePageError(w, err, "Failed to retrieve collections")
+		return
+	}
+
+	writePage(w, "collections", page, "User's collections retrieved successfully")
+}
+
+// collectionTree nests the collections under their parents, starting with the
+// children of parentId. The order of the collections is kept within each level.
+func collectionTree(collections []models.Collection, parentId *uuid.UUID) []models.Collection {
+	tree := []models.Collection{}
+	for _, collection := range collections {
+		if (collection.ParentId == nil) != (parentId == nil) || (parentId != nil && *collection.ParentId != *parentId) {
+			continue
+		}
+		collection.Children = collectionTree(collections, &collection.ID)
+		tree = append(tree, collection)
+	}
+	return tree
+}
+
+// GetCollection returns a collection with its child collections and snippets.
+// Others only see the public collections and snippets in it.
+func (c *CodeHandler) GetCollection(w http.ResponseWriter, r *http.Request) {
+	c.writeCollection(w, r.PathValue("publicId"), auth.GetUser(r).ID)
+}
+
+// GetCollectionNoAuth is GetCollection for signed out users, it is the
+// collection's public URL.
+func (c *CodeHandler) GetCollectionNoAuth(w http.ResponseWriter, r *http.Request) {
+	c.writeCollection(w, r.PathValue("publicId"), uuid.Nil)
+}
+
+func (c *CodeHandler) writeCollection(w http.ResponseWriter, publicId string, userId uuid.UUID) {
+	collection, err := queries.GetVisibleCollection(publicId, userId, c.DbClient)
+	if err != nil {
+		writeCollectionLookupError(w, publicId, err)
+		return
+	}
+	isOwner := collection.OwnerId == userId
+
+	children := c.DbClient.Model(models.Collection{}).Where("parent_id = ?", collection.ID)
+	if !isOwner {
+		children = children.Where("visibility = ?", models.VisibilityPublic)
+	}
+	if tx := children.Order("position, name").Find(&collection.Children); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to retrieve collection", Error: tx.Error.Error()})
+		return
+	}
+
+	// the owner can have added snippets of others they have the link of
+	visibilities := []models.Visibility{models.VisibilityPublic}
+	if isOwner {
+		visibilities = append(visibilities, models.VisibilityUnlisted)
+	}
+
+	var snippets []models.Snippet
+	tx := c.DbClient.Preload("Owner").Preload("Tags").Model(models.Snippet{}).
+		Joins("JOIN collection_snippets ON collection_snippets.snippet_id = snippets.id AND collection_snippets.collection_id = ?", collection.ID)
+	tx = queries.WhereSnippetVisible(tx, userId, visibilities...).
+		Order("collection_snippets.position, collection_snippets.created_at").
+		Find(&snippets)
+	if tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to retrieve collection", Error: tx.Error.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			"collection": collection,
+			"snippets":   snippets,
+		},
+		Status:  http.StatusOK,
+		Message: "Collection retrieved successfully",
+	})
+}
+
+func (c *CodeHandler) UpdateCollection(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	var body collectionRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	collection, err := queries.GetOwnedCollection(publicId, user.ID, c.DbClient)
+	if err != nil {
+		writeCollectionLookupError(w, publicId, err)
+		return
+	}
+	if err := body.apply(collection); err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		if body.Parent != nil {
+			if err := setCollectionParent(tx, collection, *body.Parent); err != nil {
+				return err
+			}
+		}
+		return tx.Model(collection).Select("name", "description", "visibility", "position", "parent_id").Updates(collection).Error
+	})
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: collection, Message: "Collection updated successfully"})
+}
+
+// DeleteCollection deletes a collection but none of its snippets. Its child
+// collections move up to its parent.
+func (c *CodeHandler) DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	collection, err := queries.GetOwnedCollection(publicId, user.ID, c.DbClient)
+	if err != nil {
+		writeCollectionLookupError(w, publicId, err)
+		return
+	}
+
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Collection{}).Where("parent_id = ?", collection.ID).Update("parent_id", collection.ParentId).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("collection_id = ?", collection.ID).Delete(&models.CollectionSnippet{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(collection).Error
+	})
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to delete collection", Error: err.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Message: "Collection deleted successfully"})
+}
+
+// AddSnippetToCollection puts a snippet the user can see in one of their
+// collections, at the end unless a position is given. Adding a snippet that is
+// already in the collection moves it.
+func (c *CodeHandler) AddSnippetToCollection(w http.ResponseWriter, r *http.Request) {
+	type addSnippetRequestBody struct {
+		Snippet  string `json:"snippet"`
+		Position *int   `json:"position"`
+	}
+
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	var body addSnippetRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	collection, err := queries.GetOwnedCollection(publicId, user.ID, c.DbClient)
+	if err != nil {
+		writeCollectionLookupError(w, publicId, err)
+		return
+	}
+
+	snippet, err := queries.GetVisibleSnippet(body.Snippet, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, body.Snippet, err)
+		return
+	}
+
+	entry := models.CollectionSnippet{CollectionId: collection.ID, SnippetId: snippet.ID}
+	if body.Position != nil {
+		entry.Position = *body.Position
+	} else {
+		var last int
+		if tx := c.DbClient.Model(models.CollectionSnippet{}).Where("collection_id = ?", collection.ID).Select("COALESCE(MAX(position), -1)").Scan(&last); tx.Error != nil {
+			utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to add snippet", Error: tx.Error.Error()})
+			return
+		}
+		entry.Position = last + 1
+	}
+
+	tx := c.DbClient.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "collection_id"}, {Name: "snippet_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"position"}),
+	}).Create(&entry)
+	if tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to add snippet", Error: tx.Error.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: entry, Message: "Snippet added to collection"})
+}
+
+func (c *CodeHandler) RemoveSnippetFromCollection(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	snippetId := r.PathValue("snippetId")
+	user := auth.GetUser(r)
+
+	collection, err := queries.GetOwnedCollection(publicId, user.ID, c.DbClient)
+	if err != nil {
+		writeCollectionLookupError(w, publicId, err)
+		return
+	}
+
+	tx := c.DbClient.
+		Where("collection_id = ? AND snippet_id IN (?)", collection.ID, c.DbClient.Model(models.Snippet{}).Select("id").Where("public_id = ?", snippetId)).
+		Delete(&models.CollectionSnippet{})
+	if tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to remove snippet", Error: tx.Error.Error()})
+		return
+	}
+	if tx.RowsAffected == 0 {
+		writeSnippetLookupError(w, snippetId, gorm.ErrRecordNotFound)
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Message: "Snippet removed from collection"})
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/internal/services/auth"
+	"code-garden-server/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const MaxCommentLength = 10000
+
+var errCommentNotFound = errors.New("comment not found")
+
+type commentRequestBody struct {
+	Body string `json:"body"`
+	// ParentId makes the comment a reply to the thread of that comment
+	ParentId  *uuid.UUID `json:"parentId"`
+	Revision  int        `json:"revision"`
+	Path      string     `json:"path"`
+	LineStart int        `json:"lineStart"`
+	LineEnd   int        `json:"lineEnd"`
+}
+
+// commentBody validates the markdown body of a comment. It's stored as written,
+// models.Comment renders it to safe HTML.
+func commentBody(body string) (string, error) {
+	if strings.TrimSpace(body) == "" {
+		return "", errors.New("empty comment")
+	}
+	if len(body) > MaxCommentLength {
+		return "", fmt.Errorf("comments can't be longer than %d characters", MaxCommentLength)
+	}
+	return body, nil
+}
+
+// validateCommentAnchor checks that the line range of an anchored comment
+// exists in the file of the revision it points at.
+func (c *CodeHandler) validateCommentAnchor(snippet *models.Snippet, body *commentRequestBody) error {
+	if body.Revision == 0 {
+		if body.Path != "" || body.LineStart != 0 || body.LineEnd != 0 {
+			return errors.New("anchored comments need a revision")
+		}
+		return nil
+	}
+
+	revision, err := queries.GetSnippetRevision(snippet.ID, body.Revision, c.DbClient)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("revision %d not found", body.Revision)
+		}
+		return err
+	}
+
+	content := revision.Code
+	if len(revision.Files) > 0 {
+		var ok bool
+		if content, ok = revision.Files[body.Path]; !ok {
+			return fmt.Errorf("revision %d has no file %q", body.Revision, body.Path)
+		}
+	} else if body.Path != "" {
+		return fmt.Errorf("revision %d has no files", body.Revision)
+	}
+
+	if body.LineStart == 0 && body.LineEnd == 0 {
+		return nil
+	}
+	if body.LineEnd == 0 {
+		body.LineEnd = body.LineStart
+	}
+	lines := strings.Count(strings.TrimSuffix(content, "\n"), "\n") + 1
+	if body.LineStart < 1 || body.LineEnd < body.LineStart || body.LineEnd > lines {
+		return fmt.Errorf("invalid line range %d-%d, the file has %d lines", body.LineStart, body.LineEnd, lines)
+	}
+	return nil
+}
+
+// getComment returns a comment on the snippet with its replies.
+func (c *CodeHandler) getComment(snippet *models.Snippet, id string) (*models.Comment, error) {
+	var comment models.Comment
+	tx := c.DbClient.Preload("Author").Preload("Replies", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}).Preload("Replies.Author").First(&comment, "id = ? AND snippet_id = ?", id, snippet.ID)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return nil, errCommentNotFound
+		}
+		return nil, tx.Error
+	}
+	return &comment, nil
+}
+
+func writeCommentLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errCommentNotFound) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Comment not found", Error: err.Error()})
+		return
+	}
+	utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "An error occurred", Error: err.Error()})
+}
+
+var commentSorts = map[string]queries.SortKey{
+	"created": {Expr: "comments.created_at", Type: "timestamptz"},
+}
+
+// ListComments returns a page of the comment threads of a snippet, oldest
+// first. The revision query parameter limits them to the ones anchored to that
+// revision.
+func (c *CodeHandler) ListComments(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	params, err := pageParamsFromQuery(r, commentSorts, "created")
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	revision := 0
+	if v := r.URL.Query().Get("revision"); v != "" {
+		if revision, err = strconv.Atoi(v); err != nil {
+			utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Invalid revision number", Error: err.Error()})
+			return
+		}
+	}
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	tx := c.DbClient.Model(models.Comment{}).Where("comments.snippet_id = ? AND comments.parent_id IS NULL", snippet.ID)
+	if revision != 0 {
+		tx = tx.Where("comments.revision = ?", revision)
+	}
+
+	page, err := queries.PaginateModels(tx, "comments", params,
+		func(comment models.Comment) uuid.UUID { return comment.ID },
+		func(db *gorm.DB) *gorm.DB {
+			return db.Preload("Author").Preload("Replies", func(db *gorm.DB) *gorm.DB {
+				return db.Order("created_at")
+			}).Preload("Replies.Author")
+		})
+	if err != nil {
+		writePageError(w, err, "Failed to retrieve comments")
+		return
+	}
+
+	writePage(w, "comments", page, "Comments retrieved successfully")
+}
+
+func (c *CodeHandler) GetComment(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	comment, err := c.getComment(snippet, r.PathValue("id"))
+	if err != nil {
+		writeCommentLookupError(w, err)
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: comment, Message: "Success"})
+}
+
+// CreateComment starts a thread on the snippet, optionally anchored to a line
+// range of a revision, or replies to one when parentId is set.
+func (c *CodeHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	var body commentRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	text, err := commentBody(body.Body)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	comment := models.Comment{SnippetId: snippet.ID, AuthorId: user.ID, Body: text}
+
+	if body.ParentId != nil {
+		if body.Revision != 0 || body.Path != "" || body.LineStart != 0 || body.LineEnd != 0 {
+			utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: "replies can't be anchored, they belong to the thread's lines"})
+			return
+		}
+
+		parent, err := c.getComment(snippet, body.ParentId.String())
+		if err != nil {
+			writeCommentLookupError(w, err)
+			return
+		}
+		// replying to a reply continues the same thread
+		comment.ParentId = &parent.ID
+		if parent.ParentId != nil {
+			comment.ParentId = parent.ParentId
+		}
+	} else {
+		if err := c.validateCommentAnchor(snippet, &body); err != nil {
+			utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Invalid anchor", Error: err.Error()})
+			return
+		}
+		comment.Revision = body.Revision
+		comment.Path = body.Path
+		comment.LineStart = body.LineStart
+		comment.LineEnd = body.LineEnd
+	}
+
+	if tx := c.DbClient.Create(&comment); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to create comment", Error: tx.Error.Error()})
+		return
+	}
+	comment.Author = *user
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusCreated, Data: comment, Message: "Comment created successfully"})
+}
+
+// UpdateComment edits the body of a comment, only its author can edit it.
+func (c *CodeHandler) UpdateComment(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	var body commentRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	text, err := commentBody(body.Body)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	comment, err := c.getComment(snippet, r.PathValue("id"))
+	if err != nil {
+		writeCommentLookupError(w, err)
+		return
+	}
+	if comment.AuthorId != user.ID {
+		utils.WriteRes(w, utils.Response{Status: http.StatusForbidden, Message: "Forbidden", Error: "only the author can edit a comment"})
+		return
+	}
+
+	if tx := c.DbClient.Model(comment).Update("body", text); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to update comment", Error: tx.Error.Error()})
+		return
+	}
+	comment.Body = text
+	comment.RenderBody()
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: comment, Message: "Comment updated successfully"})
+}
+
+// DeleteComment deletes a comment and, for a top level comment, its thread.
+// The author and the snippet's admins, its owner or the admins of its
+// organization, can delete a comment.
+func (c *CodeHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	comment, err := c.getComment(snippet, r.PathValue("id"))
+	if err != nil {
+		writeCommentLookupError(w, err)
+		return
+	}
+	if comment.AuthorId != user.ID && !snippet.Role.AtLeast(models.RoleAdmin) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusForbidden, Message: "Forbidden", Error: "only the author or the snippet's admins can delete a comment"})
+		return
+	}
+
+	if tx := c.DbClient.Where("id = ? OR parent_id = ?", comment.ID, comment.ID).Delete(&models.Comment{}); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to delete comment", Error: tx.Error.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Message: "Comment deleted successfully"})
+}
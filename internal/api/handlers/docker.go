@@ -1,27 +1,40 @@
 package handlers
 
 import (
-	"code-garden-server/internal/database"
 	"code-garden-server/internal/services/auth"
 	"code-garden-server/internal/services/docker"
+	"code-garden-server/internal/services/jobs"
 	"code-garden-server/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 )
 
 type DockerHandler struct {
-	service *docker.Service
+	service docker.Runner
+	jobs    *jobs.Queue
+	limiter *RunLimiter
+	// runOwners maps the ID of every run in progress to the user that started it
+	runOwners sync.Map
 }
 
-func NewDockerHandler(dc *client.Client, dbc *database.DBClient) *DockerHandler {
-	dockerService := docker.NewDockerService(dc, dbc)
-	return &DockerHandler{dockerService}
+func NewDockerHandler(runner docker.Runner, rds *redis.Client, limiter *RunLimiter) *DockerHandler {
+	var queue *jobs.Queue
+	// TODO: Remove this line after the project has been dockerized.
+	if rds != nil {
+		queue = jobs.NewQueue(rds)
+	}
+	return &DockerHandler{service: runner, jobs: queue, limiter: limiter}
 }
 
 func (d *DockerHandler) ListContainers(w http.ResponseWriter, _ *http.Request) {
@@ -48,19 +61,167 @@ func (d *DockerHandler) ListContainers(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
-var userToAllowedRunCount = map[string]int{}
-var userToNextResetTime = map[string]time.Time{}
+type runRequestBody struct {
+	RunID    string            `json:"runId"`
+	Code     string            `json:"code"`
+	Language string            `json:"language"`
+	Stdin    string            `json:"stdin"`
+	Args     []string          `json:"args"`
+	Env      map[string]string `json:"env"`
+	// Files and Entrypoint run a multi-file project instead of Code
+	Files      map[string]string `json:"files"`
+	Entrypoint string            `json:"entrypoint"`
+	// Async queues the run as a job instead of waiting for it to finish
+	Async bool `json:"async"`
+}
+
+func (b runRequestBody) toRunRequest() docker.RunRequest {
+	runId := b.RunID
+	if runId == "" {
+		runId = uuid.NewString()
+	}
+
+	return docker.RunRequest{
+		ID:         runId,
+		Language:   docker.Language(b.Language),
+		Code:       b.Code,
+		Stdin:      b.Stdin,
+		Args:       b.Args,
+		Env:        b.Env,
+		Files:      b.Files,
+		Entrypoint: b.Entrypoint,
+	}
+}
+
+// ListLanguages returns every language code can be run in, and whether its image
+// is ready to run code right now.
+func (d *DockerHandler) ListLanguages(w http.ResponseWriter, _ *http.Request) {
+	type language struct {
+		docker.LanguageSpec
+		Available bool `json:"available"`
+	}
+
+	languages := []language{}
+	for _, spec := range docker.Languages() {
+		languages = append(languages, language{spec, d.service.IsLanguageAvailable(spec.Name)})
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: languages, Message: "Success"})
+}
+
+// GetBuildStatuses returns the image build status of every language.
+func (d *DockerHandler) GetBuildStatuses(w http.ResponseWriter, _ *http.Request) {
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: d.service.BuildStatuses(), Message: "Success"})
+}
+
+// RebuildImage starts rebuilding the image of a language in the background.
+func (d *DockerHandler) RebuildImage(w http.ResponseWriter, r *http.Request) {
+	language := docker.Language(r.PathValue("language"))
+	if _, ok := docker.GetLanguage(language); !ok {
+		utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Unsupported Language", Error: "unsupported language"})
+		return
+	}
+
+	for _, status := range d.service.BuildStatuses() {
+		if status.Language == language && status.State == docker.BuildBuilding {
+			utils.WriteRes(w, utils.Response{Status: http.StatusConflict, Message: "Build already in progress", Error: docker.ErrBuildInProgress.Error()})
+			return
+		}
+	}
+
+	go func() {
+		if err := d.service.BuildLanguageImage(language); err != nil {
+			log.Printf("failed to rebuild image for %s: %v", language, err)
+		}
+	}()
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusAccepted, Message: "Rebuild started"})
+}
+
+func (d *DockerHandler) GetPoolStats(w http.ResponseWriter, _ *http.Request) {
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: d.service.PoolStats(), Message: "Success"})
+}
 
 const REQUESTS_ALLOWED_PER_MINUTE = 20
 const REQUESTS_ALLOWED_PER_MINUTE_NO_AUTH = 5
 
-func (d *DockerHandler) RunCodeSafe(w http.ResponseWriter, r *http.Request) {
-	type reqBody struct {
-		Code     string `json:"code"`
-		Language string `json:"language"`
+// RunLimiter limits how many runs a user can start per minute. Handlers that
+// run code for the same callers share one, so each caller has a single quota.
+type RunLimiter struct {
+	mu                    sync.Mutex
+	userToAllowedRunCount map[string]int
+	userToNextResetTime   map[string]time.Time
+	now                   func() time.Time
+}
+
+func NewRunLimiter() *RunLimiter {
+	return &RunLimiter{
+		userToAllowedRunCount: map[string]int{},
+		userToNextResetTime:   map[string]time.Time{},
+		now:                   time.Now,
 	}
+}
 
-	var body reqBody
+// consume takes one of the runs uid has left in the current minute before the
+// run starts, so concurrent requests can't all get past the limit. The returned
+// func gives the run back and is meant for requests rejected before anything
+// ran. When no runs are left a 429 response has already been written.
+func (rl *RunLimiter) consume(w http.ResponseWriter, uid string, limit int) (func(), bool) {
+	refund, ok := rl.reserve(w, uid, limit, 1)
+	if !ok {
+		return nil, false
+	}
+	return func() { refund(1) }, true
+}
+
+// reserve takes n runs at once for requests that start several, like judging a
+// snippet against its test cases. Either all n are taken or none. The returned
+// func gives back runs that didn't happen, never more than n in total.
+func (rl *RunLimiter) reserve(w http.ResponseWriter, uid string, limit int, n int) (func(int), bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	nextResetTime, okay := rl.userToNextResetTime[uid]
+	if !okay || rl.now().After(nextResetTime) {
+		nextResetTime = rl.now().Add(time.Minute)
+		rl.userToAllowedRunCount[uid] = limit
+		rl.userToNextResetTime[uid] = nextResetTime
+	}
+
+	if rl.userToAllowedRunCount[uid] < n {
+		utils.WriteRes(w, utils.Response{Status: http.StatusTooManyRequests, Message: "Too many requests have been sent. Wait for a minute", Error: "Limit exceeded"})
+		return nil, false
+	}
+	rl.userToAllowedRunCount[uid] -= n
+
+	reserved := n
+	return func(k int) {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+
+		k = min(k, reserved)
+		if k <= 0 {
+			return
+		}
+		reserved -= k
+		// a new minute started with a full allowance already
+		if rl.userToNextResetTime[uid].Equal(nextResetTime) {
+			rl.userToAllowedRunCount[uid] += k
+		}
+	}, true
+}
+
+// isRejectedRun reports whether a run failed with err before any code ran, in
+// which case it doesn't count against the caller's limit.
+func isRejectedRun(err error) bool {
+	return errors.Is(err, docker.ErrUnsupportedLanguage) ||
+		errors.Is(err, docker.ErrInvalidRunRequest) ||
+		errors.Is(err, docker.ErrLanguageUnavailable) ||
+		errors.Is(err, docker.ErrRunExists)
+}
+
+func (d *DockerHandler) RunCodeSafe(w http.ResponseWriter, r *http.Request) {
+	var body runRequestBody
 
 	defer func(body io.ReadCloser) {
 		_ = body.Close()
@@ -76,47 +237,36 @@ func (d *DockerHandler) RunCodeSafe(w http.ResponseWriter, r *http.Request) {
 	u := auth.GetUser(r)
 	uid := u.ID.String()
 
-	if _, okay := userToNextResetTime[uid]; !okay {
-		userToAllowedRunCount[uid] = REQUESTS_ALLOWED_PER_MINUTE
-		userToNextResetTime[uid] = time.Now().Add(time.Minute)
-	}
-
-	nextResetTime := userToNextResetTime[uid]
-
-	if time.Now().After(nextResetTime) {
-		userToAllowedRunCount[uid] = REQUESTS_ALLOWED_PER_MINUTE
-	}
-
-	if userToAllowedRunCount[uid] == 0 {
-		utils.WriteRes(w, utils.Response{Status: http.StatusTooManyRequests, Message: "Too many requests have been sent. Wait for a minute", Error: "Limit exceeded"})
+	refund, ok := d.limiter.consume(w, uid, REQUESTS_ALLOWED_PER_MINUTE)
+	if !ok {
 		return
 	}
 
-	defer func() {
-		userToAllowedRunCount[uid] -= 1
-		if time.Now().After(nextResetTime) {
-			userToNextResetTime[uid] = time.Now().Add(time.Minute)
+	if body.Async {
+		if !d.enqueueRun(w, r, uid, body) {
+			refund()
 		}
-		fmt.Println(userToNextResetTime, userToAllowedRunCount)
-	}()
+		return
+	}
 
-	res, err := d.service.RunLanguageContainer(docker.Language(body.Language), body.Code)
-	fmt.Println("finished running language container", res)
-	if err != nil {
-		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Data: res, Error: fmt.Sprintf("internal server error: %s", err.Error()), Message: "Error"})
+	rr := body.toRunRequest()
+	forget, ok := d.trackRunOwner(rr.ID, uid)
+	if !ok {
+		refund()
+		writeExecutionResult(w, nil, docker.ErrRunExists)
 		return
 	}
+	defer forget()
 
-	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: res, Message: "Success"})
+	res, err := d.service.RunLanguageContainer(r.Context(), rr)
+	if isRejectedRun(err) {
+		refund()
+	}
+	writeExecutionResult(w, res, err)
 }
 
 func (d *DockerHandler) RunCodeSafeNoAuth(w http.ResponseWriter, r *http.Request) {
-	type reqBody struct {
-		Code     string `json:"code"`
-		Language string `json:"language"`
-	}
-
-	var body reqBody
+	var body runRequestBody
 
 	defer func(body io.ReadCloser) {
 		_ = body.Close()
@@ -131,34 +281,228 @@ func (d *DockerHandler) RunCodeSafeNoAuth(w http.ResponseWriter, r *http.Request
 
 	uid := r.RemoteAddr
 
-	if _, okay := userToNextResetTime[uid]; !okay {
-		userToAllowedRunCount[uid] = REQUESTS_ALLOWED_PER_MINUTE_NO_AUTH
-		userToNextResetTime[uid] = time.Now().Add(time.Minute)
+	refund, ok := d.limiter.consume(w, uid, REQUESTS_ALLOWED_PER_MINUTE_NO_AUTH)
+	if !ok {
+		return
 	}
 
-	nextResetTime := userToNextResetTime[uid]
+	res, err := d.service.RunLanguageContainer(r.Context(), body.toRunRequest())
+	if isRejectedRun(err) {
+		refund()
+	}
+	writeExecutionResult(w, res, err)
+}
 
-	if time.Now().After(nextResetTime) {
-		userToAllowedRunCount[uid] = REQUESTS_ALLOWED_PER_MINUTE_NO_AUTH
+// enqueueRun queues the run for a worker and responds with the job, which can be
+// polled through GetJob. It reports whether the run was queued.
+func (d *DockerHandler) enqueueRun(w http.ResponseWriter, r *http.Request, uid string, body runRequestBody) bool {
+	if d.jobs == nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusServiceUnavailable, Message: "Error", Error: "async runs are not available"})
+		return false
 	}
 
-	if userToAllowedRunCount[uid] == 0 {
-		utils.WriteRes(w, utils.Response{Status: http.StatusTooManyRequests, Message: "Too many requests have been sent. Wait for a minute", Error: "Limit exceeded"})
+	rr := body.toRunRequest()
+	if _, ok := docker.GetLanguage(rr.Language); !ok {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Unsupported Language", Error: "unsupported language"})
+		return false
+	}
+	if !d.service.IsLanguageAvailable(rr.Language) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusServiceUnavailable, Message: "Language unavailable", Error: docker.ErrLanguageUnavailable.Error()})
+		return false
+	}
+	if err := rr.Validate(); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return false
+	}
+
+	job, err := d.jobs.Enqueue(r.Context(), uid, rr)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to queue run", Error: err.Error()})
+		return false
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusAccepted, Data: job, Message: "Run queued"})
+	return true
+}
+
+func (d *DockerHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	if d.jobs == nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusServiceUnavailable, Message: "Error", Error: "async runs are not available"})
 		return
 	}
 
-	defer func() {
-		userToAllowedRunCount[uid] -= 1
-		if time.Now().After(nextResetTime) {
-			userToNextResetTime[uid] = time.Now().Add(time.Minute)
+	job, err := d.jobs.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, jobs.ErrJobNotFound) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Job not found", Error: err.Error()})
+			return
 		}
-	}()
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to get job", Error: err.Error()})
+		return
+	}
+
+	if job.OwnerId != auth.GetUser(r).ID.String() {
+		utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Job not found", Error: jobs.ErrJobNotFound.Error()})
+		return
+	}
 
-	res, err := d.service.RunLanguageContainer(docker.Language(body.Language), body.Code)
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: job, Message: "Success"})
+}
+
+// trackRunOwner remembers who started a run so only they can cancel it. It
+// reports false, and keeps the current owner, when a run with the ID is already
+// in progress. Otherwise the returned func forgets the run again.
+func (d *DockerHandler) trackRunOwner(runId, uid string) (func(), bool) {
+	if _, loaded := d.runOwners.LoadOrStore(runId, uid); loaded {
+		return nil, false
+	}
+	return func() {
+		d.runOwners.Delete(runId)
+	}, true
+}
+
+// CancelRun stops a run started by the current user, either one running in this
+// process or one queued as a job.
+func (d *DockerHandler) CancelRun(w http.ResponseWriter, r *http.Request) {
+	runId := r.PathValue("runId")
+	uid := auth.GetUser(r).ID.String()
+
+	if owner, ok := d.runOwners.Load(runId); ok && owner == uid {
+		d.service.Cancel(runId)
+		utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: map[string]string{"runId": runId}, Message: "Run cancelled"})
+		return
+	}
+
+	if d.jobs != nil {
+		job, err := d.jobs.Get(r.Context(), runId)
+		if err == nil && job.OwnerId == uid {
+			if job.Status != jobs.StatusQueued && job.Status != jobs.StatusRunning {
+				utils.WriteRes(w, utils.Response{Status: http.StatusConflict, Message: "Run already finished", Error: fmt.Sprintf("run is %s", job.Status)})
+				return
+			}
+
+			if err := d.jobs.Cancel(r.Context(), job); err != nil {
+				utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to cancel run", Error: err.Error()})
+				return
+			}
+			utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: map[string]string{"runId": runId}, Message: "Run cancelled"})
+			return
+		}
+		if err != nil && !errors.Is(err, jobs.ErrJobNotFound) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to cancel run", Error: err.Error()})
+			return
+		}
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Run not found", Error: fmt.Sprintf("no run with id %s in progress", runId)})
+}
+
+// writeExecutionResult responds with the result of a run. Errors here are failures
+// of the server itself; a program that failed still gets a 200 with its exit code.
+func writeExecutionResult(w http.ResponseWriter, res *docker.ExecutionResult, err error) {
 	if err != nil {
-		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Data: res, Error: fmt.Sprintf("internal server error: %s", err.Error()), Message: "Error"})
+		if errors.Is(err, docker.ErrUnsupportedLanguage) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Error: err.Error(), Message: "Unsupported Language"})
+			return
+		}
+		if errors.Is(err, docker.ErrInvalidRunRequest) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Error: err.Error(), Message: "Bad request"})
+			return
+		}
+		if errors.Is(err, docker.ErrLanguageUnavailable) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusServiceUnavailable, Error: err.Error(), Message: "Language unavailable"})
+			return
+		}
+		if errors.Is(err, docker.ErrRunExists) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusConflict, Error: err.Error(), Message: "Run already in progress"})
+			return
+		}
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Error: fmt.Sprintf("internal server error: %s", err.Error()), Message: "Error"})
 		return
 	}
 
 	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: res, Message: "Success"})
 }
+
+// RunCodeStream runs the code like RunCodeSafe but streams the output back as
+// server-sent events while the program is running. Each stdout/stderr frame is
+// sent as its own event and the stream ends with an "exit" event.
+func (d *DockerHandler) RunCodeStream(w http.ResponseWriter, r *http.Request) {
+	var body runRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Error: fmt.Sprintf("bad request: failed to parse req body, %s", err.Error())})
+		return
+	}
+
+	if _, ok := docker.GetLanguage(docker.Language(body.Language)); !ok {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Unsupported Language", Error: "unsupported language"})
+		return
+	}
+	if !d.service.IsLanguageAvailable(docker.Language(body.Language)) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusServiceUnavailable, Message: "Language unavailable", Error: docker.ErrLanguageUnavailable.Error()})
+		return
+	}
+
+	rr := body.toRunRequest()
+	if err := rr.Validate(); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Error", Error: "internal server error: streaming unsupported"})
+		return
+	}
+
+	u := auth.GetUser(r)
+
+	refund, ok := d.limiter.consume(w, u.ID.String(), REQUESTS_ALLOWED_PER_MINUTE)
+	if !ok {
+		return
+	}
+
+	forget, ok := d.trackRunOwner(rr.ID, u.ID.String())
+	if !ok {
+		refund()
+		writeExecutionResult(w, nil, docker.ErrRunExists)
+		return
+	}
+	defer forget()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Status", strconv.Itoa(http.StatusOK))
+	w.Header().Set("Message", "Streaming")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	emit := func(event docker.StreamEvent) {
+		writeStreamEvent(w, string(event.Type), event)
+		flusher.Flush()
+	}
+
+	err = d.service.RunLanguageContainerStream(r.Context(), rr, emit)
+	if isRejectedRun(err) {
+		refund()
+	}
+	if err != nil {
+		writeStreamEvent(w, "error", map[string]string{"error": err.Error()})
+		flusher.Flush()
+	}
+}
+
+func writeStreamEvent(w io.Writer, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
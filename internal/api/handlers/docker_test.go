@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/services/docker"
+	"code-garden-server/internal/services/docker/fake"
+	"code-garden-server/internal/services/jobs"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMain(m *testing.M) {
+	err := docker.SetLanguages([]docker.LanguageSpec{{
+		Name:       "python",
+		Image:      "code-garden-python",
+		Dockerfile: "Dockerfile_python",
+		SourceFile: "file.py",
+		RunCommand: "python3 file.py",
+	}})
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+type testResponse struct {
+	Status  int             `json:"status"`
+	Message string          `json:"message"`
+	Error   string          `json:"error"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// newUserRequest returns a request made by the user with the given id, as the
+// auth middleware would pass it on.
+func newUserRequest(method, target, body string, userId uuid.UUID) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	user := &models.User{BaseModel: models.BaseModel{ID: userId}}
+	return r.WithContext(context.WithValue(r.Context(), "User", user))
+}
+
+func serve(t *testing.T, handler http.HandlerFunc, r *http.Request) testResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	var res testResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if res.Status != w.Code {
+		t.Fatalf("status %d in body, %d in header", res.Status, w.Code)
+	}
+	return res
+}
+
+// remainingRuns returns how many runs uid has left in the current minute.
+func remainingRuns(rl *RunLimiter, uid string) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.userToAllowedRunCount[uid]
+}
+
+func TestRunCodeSafe(t *testing.T) {
+	runner := fake.NewRunner()
+	limiter := NewRunLimiter()
+	d := NewDockerHandler(runner, nil, limiter)
+	uid := uuid.New()
+
+	res := serve(t, d.RunCodeSafe, newUserRequest("POST", "/code-runner", `{"language":"python","code":"print(input())","stdin":"hi"}`, uid))
+	if res.Status != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.Status, res.Error)
+	}
+
+	var result docker.ExecutionResult
+	if err := json.Unmarshal(res.Data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Stdout != "hi" {
+		t.Errorf("got stdout %q, want %q", result.Stdout, "hi")
+	}
+	if result.RunID == "" {
+		t.Error("the run got no id")
+	}
+	if got := remainingRuns(limiter, uid.String()); got != REQUESTS_ALLOWED_PER_MINUTE-1 {
+		t.Errorf("%d runs left, want %d", got, REQUESTS_ALLOWED_PER_MINUTE-1)
+	}
+}
+
+func TestRunCodeSafeUnsupportedLanguage(t *testing.T) {
+	limiter := NewRunLimiter()
+	d := NewDockerHandler(fake.NewRunner(), nil, limiter)
+	uid := uuid.New()
+
+	res := serve(t, d.RunCodeSafe, newUserRequest("POST", "/code-runner", `{"language":"cobol","code":"DISPLAY 'hi'."}`, uid))
+	if res.Status != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", res.Status, http.StatusBadRequest)
+	}
+	if got := remainingRuns(limiter, uid.String()); got != REQUESTS_ALLOWED_PER_MINUTE {
+		t.Errorf("rejected run wasn't refunded, %d runs left", got)
+	}
+}
+
+func TestRunCodeSafeRunnerError(t *testing.T) {
+	limiter := NewRunLimiter()
+	d := NewDockerHandler(fake.NewRunner(fake.Response{Err: errors.New("docker is down")}), nil, limiter)
+	uid := uuid.New()
+
+	res := serve(t, d.RunCodeSafe, newUserRequest("POST", "/code-runner", `{"language":"python","code":"print(1)"}`, uid))
+	if res.Status != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d", res.Status, http.StatusInternalServerError)
+	}
+	if !strings.Contains(res.Error, "docker is down") {
+		t.Errorf("got error %q", res.Error)
+	}
+	// the run may have started, so it still counts
+	if got := remainingRuns(limiter, uid.String()); got != REQUESTS_ALLOWED_PER_MINUTE-1 {
+		t.Errorf("%d runs left, want %d", got, REQUESTS_ALLOWED_PER_MINUTE-1)
+	}
+}
+
+func TestRunCodeSafeRunIdInProgress(t *testing.T) {
+	runner := fake.NewRunner()
+	limiter := NewRunLimiter()
+	d := NewDockerHandler(runner, nil, limiter)
+	owner, other := uuid.New(), uuid.New()
+
+	forget, ok := d.trackRunOwner("run-1", owner.String())
+	if !ok {
+		t.Fatal("failed to track the run")
+	}
+	defer forget()
+
+	res := serve(t, d.RunCodeSafe, newUserRequest("POST", "/code-runner", `{"runId":"run-1","language":"python","code":"print(1)"}`, other))
+	if res.Status != http.StatusConflict {
+		t.Fatalf("got status %d, want %d", res.Status, http.StatusConflict)
+	}
+	if len(runner.Requests()) != 0 {
+		t.Error("the run was started")
+	}
+	if got, _ := d.runOwners.Load("run-1"); got != owner.String() {
+		t.Errorf("run is owned by %v, want %s", got, owner)
+	}
+	if got := remainingRuns(limiter, other.String()); got != REQUESTS_ALLOWED_PER_MINUTE {
+		t.Errorf("rejected run wasn't refunded, %d runs left", got)
+	}
+}
+
+func TestCancelRun(t *testing.T) {
+	runner := fake.NewRunner()
+	d := NewDockerHandler(runner, nil, NewRunLimiter())
+	owner, other := uuid.New(), uuid.New()
+
+	forget, _ := d.trackRunOwner("run-1", owner.String())
+	defer forget()
+
+	r := newUserRequest("DELETE", "/code-runner/run-1", "", other)
+	r.SetPathValue("runId", "run-1")
+	if res := serve(t, d.CancelRun, r); res.Status != http.StatusNotFound {
+		t.Fatalf("other user got status %d, want %d", res.Status, http.StatusNotFound)
+	}
+	if len(runner.Cancelled()) != 0 {
+		t.Fatal("another user cancelled the run")
+	}
+
+	r = newUserRequest("DELETE", "/code-runner/run-1", "", owner)
+	r.SetPathValue("runId", "run-1")
+	if res := serve(t, d.CancelRun, r); res.Status != http.StatusOK {
+		t.Fatalf("owner got status %d, want %d", res.Status, http.StatusOK)
+	}
+	if cancelled := runner.Cancelled(); len(cancelled) != 1 || cancelled[0] != "run-1" {
+		t.Errorf("got cancelled runs %v", cancelled)
+	}
+}
+
+func TestGetJob(t *testing.T) {
+	rds := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	d := NewDockerHandler(fake.NewRunner(), rds, NewRunLimiter())
+	owner, other := uuid.New(), uuid.New()
+
+	job, err := jobs.NewQueue(rds).Enqueue(context.Background(), owner.String(), docker.RunRequest{Language: "python", Code: "print(1)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(id string, uid uuid.UUID) testResponse {
+		r := newUserRequest("GET", "/jobs/"+id, "", uid)
+		r.SetPathValue("id", id)
+		return serve(t, d.GetJob, r)
+	}
+
+	res := get(job.ID, owner)
+	if res.Status != http.StatusOK {
+		t.Fatalf("owner got status %d, want %d", res.Status, http.StatusOK)
+	}
+	var got jobs.Job
+	if err := json.Unmarshal(res.Data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != job.ID || got.Status != jobs.StatusQueued {
+		t.Errorf("got job %s with status %s", got.ID, got.Status)
+	}
+
+	if res := get(job.ID, other); res.Status != http.StatusNotFound {
+		t.Errorf("other user got status %d, want %d", res.Status, http.StatusNotFound)
+	}
+	if res := get(uuid.NewString(), owner); res.Status != http.StatusNotFound {
+		t.Errorf("unknown job got status %d, want %d", res.Status, http.StatusNotFound)
+	}
+}
+
+func TestRunLimiter(t *testing.T) {
+	rl := NewRunLimiter()
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, ok := rl.consume(httptest.NewRecorder(), "user", 3); !ok {
+			t.Fatalf("run %d was limited", i+1)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	if _, ok := rl.consume(w, "user", 3); ok {
+		t.Fatal("run past the limit was allowed")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	if _, ok := rl.consume(httptest.NewRecorder(), "other", 3); !ok {
+		t.Error("another user was limited")
+	}
+
+	now = now.Add(time.Minute + time.Second)
+	if _, ok := rl.consume(httptest.NewRecorder(), "user", 3); !ok {
+		t.Fatal("the limit wasn't reset after a minute")
+	}
+	if got := remainingRuns(rl, "user"); got != 2 {
+		t.Errorf("%d runs left after the reset, want 2", got)
+	}
+}
+
+func TestRunLimiterRefund(t *testing.T) {
+	rl := NewRunLimiter()
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+
+	refund, _ := rl.consume(httptest.NewRecorder(), "user", 3)
+	refund()
+	refund()
+	if got := remainingRuns(rl, "user"); got != 3 {
+		t.Errorf("%d runs left after refunding twice, want 3", got)
+	}
+
+	// a refund from before the reset doesn't add to the new allowance
+	refund, _ = rl.consume(httptest.NewRecorder(), "user", 3)
+	now = now.Add(time.Minute + time.Second)
+	rl.consume(httptest.NewRecorder(), "user", 3)
+	refund()
+	if got := remainingRuns(rl, "user"); got != 2 {
+		t.Errorf("%d runs left, want 2", got)
+	}
+}
+
+func TestRunLimiterReserve(t *testing.T) {
+	rl := NewRunLimiter()
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+
+	if _, ok := rl.reserve(httptest.NewRecorder(), "user", 5, 6); ok {
+		t.Fatal("more runs than the limit were reserved")
+	}
+	if got := remainingRuns(rl, "user"); got != 5 {
+		t.Errorf("%d runs left after a rejected reservation, want 5", got)
+	}
+
+	refund, ok := rl.reserve(httptest.NewRecorder(), "user", 5, 4)
+	if !ok {
+		t.Fatal("the reservation was limited")
+	}
+	refund(2)
+	refund(3)
+	if got := remainingRuns(rl, "user"); got != 5 {
+		t.Errorf("%d runs left after refunding more than reserved, want 5", got)
+	}
+}
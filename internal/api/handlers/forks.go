@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/internal/services/auth"
+	"code-garden-server/utils"
+	"errors"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// MaxAncestryDepth bounds how far GetSnippetAncestry follows a fork chain.
+const MaxAncestryDepth = 50
+
+// ListForks returns a page of the forks of a snippet that the user can see,
+// newest first unless sorted otherwise.
+func (c *CodeHandler) ListForks(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	params, err := pageParamsFromQuery(r, queries.SnippetSorts, "recent")
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	filter, err := snippetFilterFromQuery(r)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	tx := c.DbClient.Model(models.Snippet{}).Where("snippets.forked_from_id = ?", snippet.ID)
+	tx = queries.WhereSnippetVisible(tx, user.ID, models.VisibilityPublic)
+
+	page, err := queries.PaginateSnippets(filter.Apply(tx), params, "Owner")
+	if err != nil {
+		writePageError(w, err, "Failed to retrieve forks")
+		return
+	}
+
+	writePage(w, "snippets", page, "Forks retrieved successfully")
+}
+
+// GetSnippetAncestry returns the chain of snippets a snippet was forked from,
+// starting with its parent. The chain stops early at a snippet the user can't
+// see, which is reported as truncated.
+func (c *CodeHandler) GetSnippetAncestry(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	type ancestor struct {
+		PublicId string `json:"publicId"`
+		Name     string `json:"name"`
+		OwnerId  string `json:"ownerId"`
+		// Revision is the revision of this ancestor the next snippet was forked at
+		Revision int `json:"revision"`
+	}
+
+	ancestors := []ancestor{}
+	truncated := false
+	current := snippet
+	for current.ForkedFromId != nil {
+		if len(ancestors) == MaxAncestryDepth {
+			truncated = true
+			break
+		}
+
+		parent, err := queries.GetVisibleSnippetById(*current.ForkedFromId, user.ID, c.DbClient)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				truncated = true
+				break
+			}
+			utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "An error occurred", Error: err.Error()})
+			return
+		}
+
+		ancestors = append(ancestors, ancestor{
+			PublicId: parent.PublicId,
+			Name:     parent.Name,
+			OwnerId:  parent.OwnerId.String(),
+			Revision: current.ForkedFromRevision,
+		})
+		current = parent
+	}
+
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			"ancestors": ancestors,
+			"truncated": truncated,
+		},
+		Status:  http.StatusOK,
+		Message: "Success",
+	})
+}
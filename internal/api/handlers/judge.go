@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database"
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/internal/services/auth"
+	"code-garden-server/internal/services/docker"
+	"code-garden-server/internal/services/judge"
+	"code-garden-server/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+type JudgeHandler struct {
+	DbClient *database.DBClient
+	service  docker.Runner
+	limiter  *RunLimiter
+}
+
+func NewJudgeHandler(dbClient *database.DBClient, runner docker.Runner, limiter *RunLimiter) *JudgeHandler {
+	return &JudgeHandler{DbClient: dbClient, service: runner, limiter: limiter}
+}
+
+type testCaseRequestBody struct {
+	Name           *string `json:"name"`
+	Position       *int    `json:"position"`
+	Stdin          *string `json:"stdin"`
+	ExpectedStdout *string `json:"expectedStdout"`
+	Hidden         *bool   `json:"hidden"`
+	TimeLimitMs    *int64  `json:"timeLimitMs"`
+}
+
+func (b testCaseRequestBody) validate() error {
+	if b.Stdin != nil && len(*b.Stdin) > docker.MaxStdinBytes {
+		return fmt.Errorf("stdin is larger than %d bytes", docker.MaxStdinBytes)
+	}
+	if b.TimeLimitMs != nil && *b.TimeLimitMs < 0 {
+		return errors.New("time limit can't be negative")
+	}
+	return nil
+}
+
+// apply copies the fields present in the body onto tc.
+func (b testCaseRequestBody) apply(tc *models.TestCase) {
+	if b.Name != nil {
+		tc.Name = *b.Name
+	}
+	if b.Position != nil {
+		tc.Position = *b.Position
+	}
+	if b.Stdin != nil {
+		tc.Stdin = *b.Stdin
+	}
+	if b.ExpectedStdout != nil {
+		tc.ExpectedStdout = *b.ExpectedStdout
+	}
+	if b.Hidden != nil {
+		tc.Hidden = *b.Hidden
+	}
+	if b.TimeLimitMs != nil {
+		tc.TimeLimitMs = *b.TimeLimitMs
+	}
+}
+
+// ListTestCases returns the test cases of a snippet. The input and expected
+// output of hidden cases are only shown to those who can edit the snippet.
+func (j *JudgeHandler) ListTestCases(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), j.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	testCases, err := queries.GetSnippetTestCases(snippet.ID, j.DbClient)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to retrieve test cases", Error: err.Error()})
+		return
+	}
+
+	if !snippet.Role.AtLeast(models.RoleEditor) {
+		for i := range testCases {
+			if testCases[i].Hidden {
+				testCases[i].Stdin = ""
+				testCases[i].ExpectedStdout = ""
+			}
+		}
+	}
+
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			"testCases": testCases,
+			"total":     len(testCases),
+		},
+		Status:  http.StatusOK,
+		Message: "Test cases retrieved successfully",
+	})
+}
+
+func (j *JudgeHandler) CreateTestCase(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	var body testCaseRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	if err := body.validate(); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	snippet, err := queries.GetEditableSnippet(publicId, user.ID, j.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	var count int64
+	if tx := j.DbClient.Model(models.TestCase{}).Where("snippet_id = ?", snippet.ID).Count(&count); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to create test case", Error: tx.Error.Error()})
+		return
+	}
+	if count >= judge.MaxTestCases {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Too many test cases", Error: fmt.Sprintf("a snippet can have at most %d test cases", judge.MaxTestCases)})
+		return
+	}
+
+	// new cases go last unless a position is given
+	testCase := models.TestCase{SnippetId: snippet.ID, Position: int(count)}
+	body.apply(&testCase)
+
+	if tx := j.DbClient.Create(&testCase); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to create test case", Error: tx.Error.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusCreated, Data: testCase, Message: "Test case created successfully"})
+}
+
+func (j *JudgeHandler) UpdateTestCase(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	var body testCaseRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	if err := body.validate(); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	snippet, err := queries.GetEditableSnippet(publicId, user.ID, j.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	var testCase models.TestCase
+	if tx := j.DbClient.First(&testCase, "id = ? AND snippet_id = ?", r.PathValue("id"), snippet.ID); tx.Error != nil {
+		writeTestCaseLookupError(w, tx.Error)
+		return
+	}
+
+	body.apply(&testCase)
+	if tx := j.DbClient.Save(&testCase); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to update test case", Error: tx.Error.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: testCase, Message: "Test case updated successfully"})
+}
+
+func (j *JudgeHandler) DeleteTestCase(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	snippet, err := queries.GetEditableSnippet(publicId, user.ID, j.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	var testCase models.TestCase
+	if tx := j.DbClient.First(&testCase, "id = ? AND snippet_id = ?", r.PathValue("id"), snippet.ID); tx.Error != nil {
+		writeTestCaseLookupError(w, tx.Error)
+		return
+	}
+
+	if tx := j.DbClient.Delete(&testCase); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to delete test case", Error: tx.Error.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: testCase, Message: "Test case deleted successfully"})
+}
+
+// JudgeSnippet runs code against every test case of a snippet and responds with
+// a verdict per case. The code and language default to the snippet's own, so
+// candidates can submit their solution to an exercise they can only read.
+func (j *JudgeHandler) JudgeSnippet(w http.ResponseWriter, r *http.Request) {
+	type judgeRequestBody struct {
+		Code       string            `json:"code"`
+		Language   string            `json:"language"`
+		Args       []string          `json:"args"`
+		Files      map[string]string `json:"files"`
+		Entrypoint string            `json:"entrypoint"`
+	}
+
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	var body judgeRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	// an empty body judges the snippet as it is
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), j.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	testCases, err := queries.GetSnippetTestCases(snippet.ID, j.DbClient)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to retrieve test cases", Error: err.Error()})
+		return
+	}
+	if len(testCases) == 0 {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "No test cases", Error: "the snippet has no test cases"})
+		return
+	}
+
+	files, err := queries.GetSnippetFiles(snippet.ID, j.DbClient)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to retrieve snippet files", Error: err.Error()})
+		return
+	}
+	snippet.Files = files
+
+	sub := judge.Submission{
+		Language:   docker.Language(snippet.Language),
+		Code:       snippet.Code,
+		Args:       snippet.Args,
+		Files:      snippet.FileMap(),
+		Entrypoint: snippet.Entrypoint,
+	}
+	// submitted code or files replace the snippet's program entirely
+	if body.Code != "" || len(body.Files) > 0 {
+		sub.Code, sub.Files, sub.Entrypoint = body.Code, body.Files, body.Entrypoint
+	}
+	if body.Language != "" {
+		sub.Language = docker.Language(body.Language)
+	}
+	if body.Args != nil {
+		sub.Args = body.Args
+	}
+
+	// every case counts as a run, they are all reserved before the first starts
+	if len(testCases) > REQUESTS_ALLOWED_PER_MINUTE {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Too many test cases", Error: fmt.Sprintf("at most %d test cases can be judged at once", REQUESTS_ALLOWED_PER_MINUTE)})
+		return
+	}
+	refund, ok := j.limiter.reserve(w, user.ID.String(), REQUESTS_ALLOWED_PER_MINUTE, len(testCases))
+	if !ok {
+		return
+	}
+
+	report, err := judge.Run(r.Context(), j.service, sub, testCases, snippet.Role.AtLeast(models.RoleEditor))
+	ran := report.Ran
+	if isRejectedRun(err) {
+		// the last run was rejected before it started
+		ran--
+	}
+	// cases skipped after a compile error or a failed run are given back
+	refund(len(testCases) - ran)
+	if err != nil {
+		writeExecutionResult(w, nil, err)
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: report, Message: "Success"})
+}
+
+func writeTestCaseLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Test case not found", Error: err.Error()})
+		return
+	}
+	utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "An error occurred", Error: err.Error()})
+}
@@ -0,0 +1,674 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/internal/services/auth"
+	"code-garden-server/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// InvitationLifetime is how long an invitation to an organization can be accepted.
+const InvitationLifetime = time.Hour * 24 * 7
+
+var (
+	errInvalidOrganization = errors.New("invalid organization")
+	errMemberNotFound      = errors.New("member not found")
+	errLastOwner           = errors.New("an organization needs at least one owner")
+	errInvitationNotFound  = errors.New("invitation not found")
+)
+
+// invitationEmail is the data of the organization-invitation template.
+type invitationEmail struct {
+	ClientHost       string
+	OrganizationName string
+	Token            string
+	InvitedByEmail   string
+	Role             models.Role
+}
+
+type organizationRequestBody struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// apply sets the fields of the body that were given on the organization.
+func (b organizationRequestBody) apply(organization *models.Organization) error {
+	if b.Name != nil {
+		name := strings.TrimSpace(*b.Name)
+		if utils.Slugify(name) == "" {
+			return fmt.Errorf("%w: the name needs at least one letter or digit", errInvalidOrganization)
+		}
+		organization.Name = name
+	}
+	if b.Description != nil {
+		organization.Description = *b.Description
+	}
+	return nil
+}
+
+func writeOrganizationLookupError(w http.ResponseWriter, slug string, err error) {
+	if errors.Is(err, models.ErrInsufficientRole) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusForbidden, Message: "Forbidden", Error: err.Error()})
+		return
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: fmt.Sprintf("Organization %s not found", slug), Error: err.Error()})
+		return
+	}
+	utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "An error occurred", Error: err.Error()})
+}
+
+func writeMembershipError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrInsufficientRole):
+		utils.WriteRes(w, utils.Response{Status: http.StatusForbidden, Message: "Forbidden", Error: err.Error()})
+	case errors.Is(err, errMemberNotFound):
+		utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Member not found", Error: err.Error()})
+	case errors.Is(err, errLastOwner):
+		utils.WriteRes(w, utils.Response{Status: http.StatusConflict, Message: "Conflict", Error: err.Error()})
+	default:
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to change membership", Error: err.Error()})
+	}
+}
+
+// uniqueOrganizationSlug returns the slug of the name, numbered if another
+// organization has it already. Deleted organizations keep their slug.
+func uniqueOrganizationSlug(tx *gorm.DB, name string) (string, error) {
+	base := utils.Slugify(name)
+	slug := base
+	for i := 2; ; i++ {
+		var count int64
+		if err := tx.Unscoped().Model(&models.Organization{}).Where("slug = ?", slug).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return slug, nil
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// CreateOrganization creates an organization with the user as its owner.
+func (c *CodeHandler) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	var body organizationRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	if body.Name == nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: "the organization needs a name"})
+		return
+	}
+
+	var organization models.Organization
+	if err := body.apply(&organization); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	err := c.DbClient.Transaction(func(tx *gorm.DB) error {
+		slug, err := uniqueOrganizationSlug(tx, organization.Name)
+		if err != nil {
+			return err
+		}
+		organization.Slug = slug
+		if err := tx.Create(&organization).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.Membership{OrganizationId: organization.ID, UserId: user.ID, Role: models.RoleOwner}).Error
+	})
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to create organization", Error: err.Error()})
+		return
+	}
+
+	organization.Role = models.RoleOwner
+	utils.WriteRes(w, utils.Response{Status: http.StatusCreated, Data: organization, Message: "Organization created successfully"})
+}
+
+var organizationSorts = map[string]queries.SortKey{
+	"name":   {Expr: "organizations.name", Type: "text"},
+	"recent": {Expr: "organizations.created_at", Type: "timestamptz", Desc: true},
+}
+
+// GetUserOrganizations returns a page of the organizations the user is a member
+// of, by name unless sorted otherwise, with their role in each.
+func (c *CodeHandler) GetUserOrganizations(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	params, err := pageParamsFromQuery(r, organizationSorts, "name")
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	tx := c.DbClient.Model(models.Organization{}).
+		Joins("JOIN memberships ON memberships.organization_id = organizations.id AND memberships.user_id = ?", user.ID)
+
+	load := func(ids []uuid.UUID) ([]models.Organization, error) {
+		var memberships []models.Membership
+		err := c.DbClient.Preload("Organization").
+			Where("user_id = ? AND organization_id IN ?", user.ID, ids).
+			Find(&memberships).Error
+		if err != nil {
+			return nil, err
+		}
+
+		organizations := make([]models.Organization, 0, len(memberships))
+		for _, membership := range memberships {
+			if membership.Organization == nil {
+				continue
+			}
+			organization := *membership.Organization
+			organization.Role = membership.Role
+			organizations = append(organizations, organization)
+		}
+		return organizations, nil
+	}
+
+	page, err := queries.Paginate(tx, "organizations", params, load, func(organization models.Organization) uuid.UUID { return organization.ID })
+	if err != nil {
+		writePageError(w, err, "Failed to retrieve organizations")
+		return
+	}
+
+	writePage(w, "organizations", page, "Organizations retrieved successfully")
+}
+
+// GetOrganization returns an organization of the user with its members.
+func (c *CodeHandler) GetOrganization(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	user := auth.GetUser(r)
+
+	organization, err := queries.GetOrganizationWithRole(slug, user.ID, models.RoleViewer, c.DbClient)
+	if err != nil {
+		writeOrganizationLookupError(w, slug, err)
+		return
+	}
+
+	tx := c.DbClient.Preload("User").Where("organization_id = ?", organization.ID).Order("created_at").Find(&organization.Members)
+	if tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to retrieve organization", Error: tx.Error.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: organization, Message: "Organization retrieved successfully"})
+}
+
+// UpdateOrganization renames an organization or changes its description. Its
+// slug stays the same so links to it keep working.
+func (c *CodeHandler) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	user := auth.GetUser(r)
+
+	var body organizationRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	organization, err := queries.GetOrganizationWithRole(slug, user.ID, models.RoleAdmin, c.DbClient)
+	if err != nil {
+		writeOrganizationLookupError(w, slug, err)
+		return
+	}
+	if err := body.apply(organization); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	if tx := c.DbClient.Model(organization).Select("name", "description").Updates(organization); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to update organization", Error: tx.Error.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: organization, Message: "Organization updated successfully"})
+}
+
+// DeleteOrganization deletes an organization once it has no snippets left, not
+// even in the trash, so no snippet is left without anyone who can manage it.
+// Only its owners can delete it.
+func (c *CodeHandler) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	user := auth.GetUser(r)
+
+	organization, err := queries.GetOrganizationWithRole(slug, user.ID, models.RoleOwner, c.DbClient)
+	if err != nil {
+		writeOrganizationLookupError(w, slug, err)
+		return
+	}
+
+	var snippets int64
+	if tx := c.DbClient.Unscoped().Model(&models.Snippet{}).Where("organization_id = ?", organization.ID).Count(&snippets); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to delete organization", Error: tx.Error.Error()})
+		return
+	}
+	if snippets > 0 {
+		utils.WriteRes(w, utils.Response{Status: http.StatusConflict, Message: "Conflict", Error: "move or permanently delete the organization's snippets first"})
+		return
+	}
+
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ?", organization.ID).Delete(&models.Membership{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.OrganizationInvitation{}).Where("organization_id = ? AND status = ?", organization.ID, models.InvitationPending).
+			Updates(map[string]interface{}{"status": models.InvitationRevoked, "resolved_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(organization).Error
+	})
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to delete organization", Error: err.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Message: "Organization deleted successfully"})
+}
+
+// GetOrganizationSnippets returns a page of the snippets of an organization of the user.
+func (c *CodeHandler) GetOrganizationSnippets(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	user := auth.GetUser(r)
+
+	params, err := pageParamsFromQuery(r, queries.SnippetSorts, "recent")
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	filter, err := snippetFilterFromQuery(r)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	organization, err := queries.GetOrganizationWithRole(slug, user.ID, models.RoleViewer, c.DbClient)
+	if err != nil {
+		writeOrganizationLookupError(w, slug, err)
+		return
+	}
+
+	tx := c.DbClient.Model(models.Snippet{}).Where("snippets.organization_id = ?", organization.ID)
+	page, err := queries.PaginateSnippets(filter.Apply(tx), params, "Owner", "Tags")
+	if err != nil {
+		writePageError(w, err, "Failed to retrieve organization's snippets")
+		return
+	}
+
+	for i := range page.Items {
+		page.Items[i].Role = organization.Role
+	}
+
+	writePage(w, "snippets", page, "Organization's snippets retrieved successfully")
+}
+
+// UpdateMember changes the role of a member. Members can only be given roles up
+// to their own, and admins can't change the role of owners.
+func (c *CodeHandler) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	type updateMemberRequestBody struct {
+		Role models.Role `json:"role"`
+	}
+
+	var body updateMemberRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	if !body.Role.IsValid() {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: fmt.Sprintf("invalid role %q", body.Role)})
+		return
+	}
+
+	c.changeMembership(w, r, body.Role)
+}
+
+// RemoveMember removes a member from an organization. Any member can leave,
+// removing others takes an admin. The snippets the member created stay with the
+// organization.
+func (c *CodeHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	c.changeMembership(w, r, "")
+}
+
+// changeMembership gives the member in the path the role, or removes them for
+// an empty role.
+func (c *CodeHandler) changeMembership(w http.ResponseWriter, r *http.Request, role models.Role) {
+	slug := r.PathValue("slug")
+	user := auth.GetUser(r)
+
+	memberId, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: "invalid user id"})
+		return
+	}
+
+	organization, err := queries.GetOrganizationWithRole(slug, user.ID, models.RoleViewer, c.DbClient)
+	if err != nil {
+		writeOrganizationLookupError(w, slug, err)
+		return
+	}
+
+	var member *models.Membership
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		// membership changes of an organization take turns, so it can't lose its last owner to a race
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Organization{}, "id = ?", organization.ID).Error; err != nil {
+			return err
+		}
+
+		actor, err := queries.GetMembership(organization.ID, user.ID, tx)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return models.ErrInsufficientRole
+			}
+			return err
+		}
+		member, err = queries.GetMembership(organization.ID, memberId, tx)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errMemberNotFound
+			}
+			return err
+		}
+
+		leaving := role == "" && actor.UserId == member.UserId
+		if !leaving && !(actor.Role.AtLeast(models.RoleAdmin) && actor.Role.AtLeast(member.Role) && (role == "" || actor.Role.AtLeast(role))) {
+			return models.ErrInsufficientRole
+		}
+
+		if member.Role == models.RoleOwner && role != models.RoleOwner {
+			var owners int64
+			if err := tx.Model(&models.Membership{}).Where("organization_id = ? AND role = ?", organization.ID, models.RoleOwner).Count(&owners).Error; err != nil {
+				return err
+			}
+			if owners <= 1 {
+				return errLastOwner
+			}
+		}
+
+		if role == "" {
+			return tx.Where("organization_id = ? AND user_id = ?", organization.ID, member.UserId).Delete(&models.Membership{}).Error
+		}
+		member.Role = role
+		return tx.Model(&models.Membership{}).Where("organization_id = ? AND user_id = ?", organization.ID, member.UserId).Update("role", role).Error
+	})
+	if err != nil {
+		writeMembershipError(w, err)
+		return
+	}
+
+	if role == "" {
+		utils.WriteRes(w, utils.Response{Status: http.StatusOK, Message: "Member removed successfully"})
+		return
+	}
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: member, Message: "Member updated successfully"})
+}
+
+// InviteMember invites someone to an organization by email. Admins can invite
+// with roles up to their own. Inviting the same email again replaces its
+// pending invitation.
+func (c *CodeHandler) InviteMember(w http.ResponseWriter, r *http.Request) {
+	type inviteMemberRequestBody struct {
+		Email      string      `json:"email"`
+		Role       models.Role `json:"role"`
+		ClientHost string      `json:"clientHost"`
+	}
+
+	slug := r.PathValue("slug")
+	user := auth.GetUser(r)
+
+	var body inviteMemberRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(body.Email))
+	if email == "" {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: "an email is required"})
+		return
+	}
+	if body.Role == "" {
+		body.Role = models.RoleViewer
+	}
+	if !body.Role.IsValid() {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: fmt.Sprintf("invalid role %q", body.Role)})
+		return
+	}
+
+	organization, err := queries.GetOrganizationWithRole(slug, user.ID, models.RoleAdmin, c.DbClient)
+	if err != nil {
+		writeOrganizationLookupError(w, slug, err)
+		return
+	}
+	if !organization.Role.AtLeast(body.Role) {
+		writeOrganizationLookupError(w, slug, models.ErrInsufficientRole)
+		return
+	}
+
+	var members int64
+	tx := c.DbClient.Model(&models.Membership{}).
+		Joins("JOIN users ON users.id = memberships.user_id").
+		Where("memberships.organization_id = ? AND LOWER(users.email) = ?", organization.ID, email).
+		Count(&members)
+	if tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to invite member", Error: tx.Error.Error()})
+		return
+	}
+	if members > 0 {
+		utils.WriteRes(w, utils.Response{Status: http.StatusConflict, Message: "Conflict", Error: "they're already a member of the organization"})
+		return
+	}
+
+	token, err := utils.GenerateRandomString(24)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to invite member", Error: err.Error()})
+		return
+	}
+
+	invitation := models.OrganizationInvitation{
+		OrganizationId: organization.ID,
+		Email:          email,
+		Role:           body.Role,
+		InvitedById:    user.ID,
+		Token:          token,
+		ExpiresAt:      time.Now().Add(InvitationLifetime),
+		Status:         models.InvitationPending,
+	}
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.OrganizationInvitation{}).Where("organization_id = ? AND email = ? AND status = ?", organization.ID, email, models.InvitationPending).
+			Updates(map[string]interface{}{"status": models.InvitationRevoked, "resolved_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		return tx.Omit("Organization", "InvitedBy").Create(&invitation).Error
+	})
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to invite member", Error: err.Error()})
+		return
+	}
+
+	notify([]string{email}, user.Email+" invited you to "+organization.Name, "organization-invitation", invitationEmail{
+		ClientHost:       body.ClientHost,
+		OrganizationName: organization.Name,
+		Token:            invitation.Token,
+		InvitedByEmail:   user.Email,
+		Role:             invitation.Role,
+	})
+
+	invitation.Organization, invitation.InvitedBy = *organization, *user
+	utils.WriteRes(w, utils.Response{Status: http.StatusCreated, Data: invitation, Message: "Invitation sent successfully"})
+}
+
+// ListInvitations returns the pending invitations of an organization.
+func (c *CodeHandler) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	user := auth.GetUser(r)
+
+	organization, err := queries.GetOrganizationWithRole(slug, user.ID, models.RoleAdmin, c.DbClient)
+	if err != nil {
+		writeOrganizationLookupError(w, slug, err)
+		return
+	}
+
+	var invitations []models.OrganizationInvitation
+	tx := c.DbClient.Preload("InvitedBy").
+		Where("organization_id = ? AND status = ? AND expires_at > ?", organization.ID, models.InvitationPending, time.Now()).
+		Order("created_at DESC").
+		Find(&invitations)
+	if tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to retrieve invitations", Error: tx.Error.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			"invitations": invitations,
+			"total":       len(invitations),
+		},
+		Status:  http.StatusOK,
+		Message: "Invitations retrieved successfully",
+	})
+}
+
+// RevokeInvitation revokes a pending invitation of an organization.
+func (c *CodeHandler) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	user := auth.GetUser(r)
+
+	organization, err := queries.GetOrganizationWithRole(slug, user.ID, models.RoleAdmin, c.DbClient)
+	if err != nil {
+		writeOrganizationLookupError(w, slug, err)
+		return
+	}
+
+	tx := c.DbClient.Model(&models.OrganizationInvitation{}).
+		Where("id = ? AND organization_id = ? AND status = ?", r.PathValue("id"), organization.ID, models.InvitationPending).
+		Updates(map[string]interface{}{"status": models.InvitationRevoked, "resolved_at": time.Now()})
+	if tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to revoke invitation", Error: tx.Error.Error()})
+		return
+	}
+	if tx.RowsAffected == 0 {
+		utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Invitation not found", Error: errInvitationNotFound.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Message: "Invitation revoked"})
+}
+
+// GetIncomingInvitations returns the pending invitations sent to the user's email.
+func (c *CodeHandler) GetIncomingInvitations(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	var invitations []models.OrganizationInvitation
+	tx := c.DbClient.Preload("Organization").Preload("InvitedBy").
+		Where("email = ? AND status = ? AND expires_at > ?", strings.ToLower(user.Email), models.InvitationPending, time.Now()).
+		Order("created_at DESC").
+		Find(&invitations)
+	if tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to retrieve invitations", Error: tx.Error.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			"invitations": invitations,
+			"total":       len(invitations),
+		},
+		Status:  http.StatusOK,
+		Message: "Invitations retrieved successfully",
+	})
+}
+
+// AcceptInvitation makes the user a member of the organization of an
+// invitation sent to their email. Users who already are a member keep their role.
+func (c *CodeHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	c.resolveInvitation(w, r, models.InvitationAccepted)
+}
+
+func (c *CodeHandler) DeclineInvitation(w http.ResponseWriter, r *http.Request) {
+	c.resolveInvitation(w, r, models.InvitationDeclined)
+}
+
+// resolveInvitation accepts or declines the pending invitation of the token in
+// the path, which has to be for the user's email.
+func (c *CodeHandler) resolveInvitation(w http.ResponseWriter, r *http.Request, status models.InvitationStatus) {
+	user := auth.GetUser(r)
+
+	// the invitation went to an email, so the user has to show they own it
+	if status == models.InvitationAccepted && !user.EmailVerified {
+		utils.WriteRes(w, utils.Response{Status: http.StatusForbidden, Message: "Forbidden", Error: "verify your email before accepting invitations"})
+		return
+	}
+
+	var invitation models.OrganizationInvitation
+	err := c.DbClient.Transaction(func(tx *gorm.DB) error {
+		err := tx.Preload("Organization").First(&invitation, "token = ? AND email = ?", r.PathValue("token"), strings.ToLower(user.Email)).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errInvitationNotFound
+			}
+			return err
+		}
+		if !invitation.IsValid() {
+			return errInvitationNotFound
+		}
+
+		now := time.Now()
+		res := tx.Model(&invitation).Where("status = ?", models.InvitationPending).Updates(map[string]interface{}{"status": status, "resolved_at": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errInvitationNotFound
+		}
+		invitation.Status, invitation.ResolvedAt = status, &now
+
+		if status != models.InvitationAccepted {
+			return nil
+		}
+		membership := models.Membership{OrganizationId: invitation.OrganizationId, UserId: user.ID, Role: invitation.Role}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&membership).Error
+	})
+	if err != nil {
+		if errors.Is(err, errInvitationNotFound) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Invitation not found", Error: "the invitation doesn't exist, expired or was revoked"})
+			return
+		}
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to resolve invitation", Error: err.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: invitation, Message: "Invitation " + string(status)})
+}
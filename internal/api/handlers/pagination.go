@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/utils"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// pageParamsFromQuery reads the cursor, limit, offset, sort and order (asc or
+// desc) query parameters of a list. sorts has the sort keys the list accepts.
+func pageParamsFromQuery(r *http.Request, sorts map[string]queries.SortKey, defaultSort string) (queries.PageParams, error) {
+	query := r.URL.Query()
+	params := queries.PageParams{Cursor: query.Get("cursor")}
+
+	sort := query.Get("sort")
+	if sort == "" {
+		sort = defaultSort
+	}
+	key, ok := sorts[sort]
+	if !ok {
+		return params, fmt.Errorf("unknown sort %q", sort)
+	}
+	switch query.Get("order") {
+	case "":
+	case "asc":
+		key.Desc = false
+	case "desc":
+		key.Desc = true
+	default:
+		return params, errors.New("order must be asc or desc")
+	}
+	params.Sort = key
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return params, errors.New("limit must be a positive number")
+		}
+		params.Limit = min(limit, queries.MaxPageLimit)
+	}
+	if v := query.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return params, errors.New("offset can't be negative")
+		}
+		params.Offset = offset
+	}
+	return params, nil
+}
+
+// withSnippetSorts returns sorts along with the sort keys of every snippet list.
+func withSnippetSorts(sorts map[string]queries.SortKey) map[string]queries.SortKey {
+	for name, key := range queries.SnippetSorts {
+		sorts[name] = key
+	}
+	return sorts
+}
+
+// snippetFilterFromQuery reads the language, visibility, createdAfter and
+// createdBefore query parameters of a snippet list.
+func snippetFilterFromQuery(r *http.Request) (queries.SnippetFilter, error) {
+	query := r.URL.Query()
+	filter := queries.SnippetFilter{
+		Language:   query.Get("language"),
+		Visibility: query.Get("visibility"),
+	}
+
+	if filter.Visibility != "" && !models.Visibility(filter.Visibility).IsValid() {
+		return filter, fmt.Errorf("unknown visibility %q", filter.Visibility)
+	}
+
+	var err error
+	if filter.CreatedAfter, err = parseTimeParam(query.Get("createdAfter")); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = parseTimeParam(query.Get("createdBefore")); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// writePage responds with a page of a list, the items are under key.
+func writePage[T any](w http.ResponseWriter, key string, page *queries.Page[T], message string) {
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			key:          page.Items,
+			"total":      page.Total,
+			"nextCursor": page.NextCursor,
+		},
+		Status:  http.StatusOK,
+		Message: message,
+	})
+}
+
+// writePageError responds to a list that failed to load.
+func writePageError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, queries.ErrInvalidCursor) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: message, Error: err.Error()})
+}
@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/internal/services/auth"
+	"code-garden-server/internal/services/emails"
+	"code-garden-server/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errProposalNotFound = errors.New("proposal not found")
+
+// proposalEmail is the data of every proposal notification template.
+type proposalEmail struct {
+	ClientHost  string
+	SnippetName string
+	PublicId    string
+	ProposalId  string
+	Title       string
+	AuthorEmail string
+	Status      models.ProposalStatus
+}
+
+// notify mails a template in the background, a failed notification never fails the request.
+func notify(to []string, subject, template string, data interface{}) {
+	go func() {
+		if err := emails.SendTemplate(to, subject, template, data); err != nil {
+			log.Printf("failed to send %s email: %v", template, err)
+		}
+	}()
+}
+
+// getProposal returns a proposal of the target snippet if the user may see it:
+// whoever can edit the target sees every proposal, anyone else only the ones they opened.
+func (c *CodeHandler) getProposal(target *models.Snippet, id string, user *models.User) (*models.SnippetProposal, error) {
+	var proposal models.SnippetProposal
+	tx := c.DbClient.Preload("Author").Preload("Comments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}).Preload("Comments.Author").First(&proposal, "id = ? AND target_id = ?", id, target.ID)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return nil, errProposalNotFound
+		}
+		return nil, tx.Error
+	}
+
+	if !target.Role.AtLeast(models.RoleEditor) && proposal.AuthorId != user.ID {
+		return nil, errProposalNotFound
+	}
+	return &proposal, nil
+}
+
+func writeProposalLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errProposalNotFound) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Proposal not found", Error: err.Error()})
+		return
+	}
+	utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "An error occurred", Error: err.Error()})
+}
+
+// CreateProposal proposes the current program of one of the user's forks as the
+// next revision of the snippet it was forked from, and lets that snippet's
+// owner know about it.
+func (c *CodeHandler) CreateProposal(w http.ResponseWriter, r *http.Request) {
+	type createProposalRequestBody struct {
+		// Fork is the public id of the user's fork of the snippet
+		Fork        string `json:"fork"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		ClientHost  string `json:"clientHost"`
+	}
+
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	var body createProposalRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	body.Title = strings.TrimSpace(body.Title)
+	if body.Title == "" {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: "a proposal needs a title"})
+		return
+	}
+
+	target, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	fork, err := queries.GetEditableSnippet(body.Fork, user.ID, c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, body.Fork, err)
+		return
+	}
+	if fork.ForkedFromId == nil || *fork.ForkedFromId != target.ID {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: fmt.Sprintf("%s is not a fork of %s", fork.PublicId, target.PublicId)})
+		return
+	}
+
+	var open int64
+	if tx := c.DbClient.Model(models.SnippetProposal{}).Where("source_id = ? AND status = ?", fork.ID, models.ProposalOpen).Count(&open); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to create proposal", Error: tx.Error.Error()})
+		return
+	}
+	if open > 0 {
+		utils.WriteRes(w, utils.Response{Status: http.StatusConflict, Message: "Proposal already open", Error: "the fork already has an open proposal"})
+		return
+	}
+
+	proposal := models.SnippetProposal{
+		TargetId:       target.ID,
+		SourceId:       fork.ID,
+		AuthorId:       user.ID,
+		Title:          body.Title,
+		Description:    body.Description,
+		BaseRevision:   fork.ForkedFromRevision,
+		SourceRevision: fork.Revision,
+		Status:         models.ProposalOpen,
+	}
+	if tx := c.DbClient.Create(&proposal); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to create proposal", Error: tx.Error.Error()})
+		return
+	}
+
+	var owner models.User
+	if tx := c.DbClient.First(&owner, "id = ?", target.OwnerId); tx.Error == nil {
+		notify([]string{owner.Email}, "New proposal for "+target.Name, "proposal-opened", proposalEmail{
+			ClientHost:  body.ClientHost,
+			SnippetName: target.Name,
+			PublicId:    target.PublicId,
+			ProposalId:  proposal.ID.String(),
+			Title:       proposal.Title,
+			AuthorEmail: user.Email,
+		})
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusCreated, Data: proposal, Message: "Proposal created successfully"})
+}
+
+var proposalSorts = map[string]queries.SortKey{
+	"recent":  {Expr: "snippet_proposals.created_at", Type: "timestamptz", Desc: true},
+	"updated": {Expr: "snippet_proposals.updated_at", Type: "timestamptz", Desc: true},
+}
+
+// ListProposals returns a page of the proposals for a snippet, newest first
+// unless sorted otherwise, filtered by the status query parameter when it is set.
+func (c *CodeHandler) ListProposals(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	params, err := pageParamsFromQuery(r, proposalSorts, "recent")
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	target, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	tx := c.DbClient.Model(models.SnippetProposal{}).Where("snippet_proposals.target_id = ?", target.ID)
+	if !target.Role.AtLeast(models.RoleEditor) {
+		tx = tx.Where("snippet_proposals.author_id = ?", user.ID)
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		tx = tx.Where("snippet_proposals.status = ?", status)
+	}
+
+	page, err := queries.PaginateModels(tx, "snippet_proposals", params,
+		func(proposal models.SnippetProposal) uuid.UUID { return proposal.ID },
+		queries.Preloads("Author"))
+	if err != nil {
+		writePageError(w, err, "Failed to retrieve proposals")
+		return
+	}
+
+	writePage(w, "proposals", page, "Proposals retrieved successfully")
+}
+
+// GetProposal returns a proposal with its comments and the diff from the
+// target's latest revision to the proposed one. upstreamChanged tells whether
+// the target changed since it was forked.
+func (c *CodeHandler) GetProposal(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	target, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	proposal, err := c.getProposal(target, r.PathValue("id"), user)
+	if err != nil {
+		writeProposalLookupError(w, err)
+		return
+	}
+
+	headRevision, err := queries.GetSnippetRevision(target.ID, target.Revision, c.DbClient)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to retrieve revision", Error: err.Error()})
+		return
+	}
+	sourceRevision, err := queries.GetSnippetRevision(proposal.SourceId, proposal.SourceRevision, c.DbClient)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to retrieve revision", Error: err.Error()})
+		return
+	}
+
+	diff, err := revisionDiff(headRevision, sourceRevision)
+	if err != nil {
+		writeDiffError(w, err)
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			"proposal":        proposal,
+			"diff":            diff,
+			"upstreamChanged": target.Revision != proposal.BaseRevision,
+		},
+		Status:  http.StatusOK,
+		Message: "Success",
+	})
+}
+
+// CommentOnProposal adds a comment to a proposal and lets the other side know.
+func (c *CodeHandler) CommentOnProposal(w http.ResponseWriter, r *http.Request) {
+	type commentRequestBody struct {
+		Body       string `json:"body"`
+		ClientHost string `json:"clientHost"`
+	}
+
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	var body commentRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	if strings.TrimSpace(body.Body) == "" {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: "empty comment"})
+		return
+	}
+
+	target, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	proposal, err := c.getProposal(target, r.PathValue("id"), user)
+	if err != nil {
+		writeProposalLookupError(w, err)
+		return
+	}
+
+	comment := models.ProposalComment{ProposalId: proposal.ID, AuthorId: user.ID, Body: body.Body}
+	if tx := c.DbClient.Create(&comment); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to create comment", Error: tx.Error.Error()})
+		return
+	}
+	comment.Author = *user
+
+	// whoever didn't write the comment gets notified
+	recipientId := target.OwnerId
+	if user.ID == target.OwnerId {
+		recipientId = proposal.AuthorId
+	}
+	var recipient models.User
+	if recipientId != user.ID {
+		if tx := c.DbClient.First(&recipient, "id = ?", recipientId); tx.Error == nil {
+			notify([]string{recipient.Email}, "New comment on "+proposal.Title, "proposal-comment", proposalEmail{
+				ClientHost:  body.ClientHost,
+				SnippetName: target.Name,
+				PublicId:    target.PublicId,
+				ProposalId:  proposal.ID.String(),
+				Title:       proposal.Title,
+				AuthorEmail: user.Email,
+			})
+		}
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusCreated, Data: comment, Message: "Comment created successfully"})
+}
+
+// AcceptProposal makes the proposed program the next revision of the snippet.
+func (c *CodeHandler) AcceptProposal(w http.ResponseWriter, r *http.Request) {
+	c.resolveProposal(w, r, models.ProposalAccepted)
+}
+
+func (c *CodeHandler) RejectProposal(w http.ResponseWriter, r *http.Request) {
+	c.resolveProposal(w, r, models.ProposalRejected)
+}
+
+// resolveProposal closes an open proposal with status. Only those who can edit
+// the target snippet can resolve proposals.
+func (c *CodeHandler) resolveProposal(w http.ResponseWriter, r *http.Request, status models.ProposalStatus) {
+	type resolveRequestBody struct {
+		ClientHost string `json:"clientHost"`
+	}
+
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	var body resolveRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	target, err := queries.GetEditableSnippet(publicId, user.ID, c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	proposal, err := c.getProposal(target, r.PathValue("id"), user)
+	if err != nil {
+		writeProposalLookupError(w, err)
+		return
+	}
+	if proposal.Status != models.ProposalOpen {
+		utils.WriteRes(w, utils.Response{Status: http.StatusConflict, Message: "Proposal already resolved", Error: fmt.Sprintf("proposal is %s", proposal.Status)})
+		return
+	}
+
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		updates := map[string]interface{}{
+			"status":         status,
+			"resolved_by_id": user.ID,
+			"resolved_at":    now,
+		}
+
+		if status == models.ProposalAccepted {
+			var source models.SnippetRevision
+			if err := tx.First(&source, "snippet_id = ? AND number = ?", proposal.SourceId, proposal.SourceRevision).Error; err != nil {
+				return err
+			}
+			if err := applyRevision(tx, target, &source); err != nil {
+				return err
+			}
+			merged, err := recordRevision(tx, target, proposal.AuthorId, "Merged proposal: "+proposal.Title)
+			if err != nil {
+				return err
+			}
+			updates["merged_revision"] = merged.Number
+		}
+
+		// only resolve the proposal if nobody else did in the meantime
+		res := tx.Model(&models.SnippetProposal{}).Where("id = ? AND status = ?", proposal.ID, models.ProposalOpen).Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errProposalNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errProposalNotFound) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusConflict, Message: "Proposal already resolved", Error: "proposal is no longer open"})
+			return
+		}
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to resolve proposal", Error: err.Error()})
+		return
+	}
+
+	proposal, err = c.getProposal(target, proposal.ID.String(), user)
+	if err != nil {
+		writeProposalLookupError(w, err)
+		return
+	}
+
+	notify([]string{proposal.Author.Email}, fmt.Sprintf("Your proposal was %s", status), "proposal-resolved", proposalEmail{
+		ClientHost:  body.ClientHost,
+		SnippetName: target.Name,
+		PublicId:    target.PublicId,
+		ProposalId:  proposal.ID.String(),
+		Title:       proposal.Title,
+		AuthorEmail: proposal.Author.Email,
+		Status:      status,
+	})
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: proposal, Message: fmt.Sprintf("Proposal %s", status)})
+}
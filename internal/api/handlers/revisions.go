@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/internal/services/auth"
+	"code-garden-server/utils"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// recordRevision saves the snippet's current program as its next revision. It
+// must run in the transaction that changed the snippet.
+func recordRevision(tx *gorm.DB, snippet *models.Snippet, authorId uuid.UUID, message string) (*models.SnippetRevision, error) {
+	// lock the snippet so concurrent updates can't take the same number
+	var current models.Snippet
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "revision").First(&current, "id = ?", snippet.ID).Error; err != nil {
+		return nil, err
+	}
+
+	revision := models.SnippetRevision{
+		SnippetId:  snippet.ID,
+		Number:     current.Revision + 1,
+		AuthorId:   authorId,
+		Message:    message,
+		Code:       snippet.Code,
+		Language:   snippet.Language,
+		Files:      snippet.FileMap(),
+		Entrypoint: snippet.Entrypoint,
+	}
+	if err := tx.Create(&revision).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(&models.Snippet{}).Where("id = ?", snippet.ID).UpdateColumn("revision", revision.Number).Error; err != nil {
+		return nil, err
+	}
+	snippet.Revision = revision.Number
+	return &revision, nil
+}
+
+// applyRevision replaces the snippet's program with the one of revision.
+func applyRevision(tx *gorm.DB, snippet *models.Snippet, revision *models.SnippetRevision) error {
+	updates := map[string]interface{}{
+		"code":       revision.Code,
+		"language":   revision.Language,
+		"entrypoint": revision.Entrypoint,
+	}
+	if err := tx.Model(snippet).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(revision.Files))
+	for p := range revision.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	files := make([]models.SnippetFile, 0, len(paths))
+	for _, p := range paths {
+		files = append(files, models.SnippetFile{Path: p, Content: revision.Files[p]})
+	}
+	if err := replaceSnippetFiles(tx, snippet, files); err != nil {
+		return err
+	}
+
+	return tx.Preload("Files").First(snippet, "id = ?", snippet.ID).Error
+}
+
+// revisionDiff returns a unified diff of every file that differs between two
+// revisions. It fails with utils.ErrDiffTooLarge when one of the files is too
+// large to diff.
+func revisionDiff(from, to *models.SnippetRevision) (string, error) {
+	fromFiles, toFiles := from.FileMap(), to.FileMap()
+
+	paths := make([]string, 0, len(fromFiles)+len(toFiles))
+	for p := range fromFiles {
+		paths = append(paths, p)
+	}
+	for p := range toFiles {
+		if _, ok := fromFiles[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var diff strings.Builder
+	for _, p := range paths {
+		fromName, toName := "a/"+p, "b/"+p
+		fromContent, inFrom := fromFiles[p]
+		toContent, inTo := toFiles[p]
+		if !inFrom {
+			fromName = "/dev/null"
+		}
+		if !inTo {
+			toName = "/dev/null"
+		}
+		fileDiff, err := utils.UnifiedDiff(fromName, toName, fromContent, toContent)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", p, err)
+		}
+		diff.WriteString(fileDiff)
+	}
+	return diff.String(), nil
+}
+
+// writeDiffError responds to a failure of revisionDiff.
+func writeDiffError(w http.ResponseWriter, err error) {
+	if errors.Is(err, utils.ErrDiffTooLarge) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusRequestEntityTooLarge, Message: "Diff too large", Error: err.Error()})
+		return
+	}
+	utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to diff revisions", Error: err.Error()})
+}
+
+var revisionSorts = map[string]queries.SortKey{
+	"number": {Expr: "snippet_revisions.number", Type: "bigint", Desc: true},
+}
+
+// ListRevisions returns a page of the revisions of a snippet, newest first,
+// without their programs.
+func (c *CodeHandler) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	params, err := pageParamsFromQuery(r, revisionSorts, "number")
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	tx := c.DbClient.Model(models.SnippetRevision{}).Where("snippet_revisions.snippet_id = ?", snippet.ID)
+	page, err := queries.PaginateModels(tx, "snippet_revisions", params,
+		func(rev models.SnippetRevision) uuid.UUID { return rev.ID },
+		func(db *gorm.DB) *gorm.DB { return db.Omit("code", "files").Preload("Author") })
+	if err != nil {
+		writePageError(w, err, "Failed to retrieve revisions")
+		return
+	}
+
+	writePage(w, "revisions", page, "Revisions retrieved successfully")
+}
+
+func (c *CodeHandler) GetRevision(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Invalid revision number", Error: err.Error()})
+		return
+	}
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	revision, err := queries.GetSnippetRevision(snippet.ID, number, c.DbClient)
+	if err != nil {
+		writeRevisionLookupError(w, number, err)
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: revision, Message: "Revision retrieved successfully"})
+}
+
+// DiffRevisions returns a unified diff between the revisions given by the from
+// and to query parameters. to defaults to the latest revision and from to the
+// one before to.
+func (c *CodeHandler) DiffRevisions(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	to := snippet.Revision
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = strconv.Atoi(v); err != nil {
+			utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Invalid revision number", Error: err.Error()})
+			return
+		}
+	}
+	from := to - 1
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = strconv.Atoi(v); err != nil {
+			utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Invalid revision number", Error: err.Error()})
+			return
+		}
+	}
+
+	fromRevision, err := queries.GetSnippetRevision(snippet.ID, from, c.DbClient)
+	if err != nil {
+		writeRevisionLookupError(w, from, err)
+		return
+	}
+	toRevision, err := queries.GetSnippetRevision(snippet.ID, to, c.DbClient)
+	if err != nil {
+		writeRevisionLookupError(w, to, err)
+		return
+	}
+
+	diff, err := revisionDiff(fromRevision, toRevision)
+	if err != nil {
+		writeDiffError(w, err)
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			"from": from,
+			"to":   to,
+			"diff": diff,
+		},
+		Status:  http.StatusOK,
+		Message: "Success",
+	})
+}
+
+// RestoreRevision makes an old revision the snippet's program again, saved as a
+// new revision so history is never rewritten.
+func (c *CodeHandler) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Invalid revision number", Error: err.Error()})
+		return
+	}
+
+	snippet, err := queries.GetEditableSnippet(publicId, user.ID, c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	revision, err := queries.GetSnippetRevision(snippet.ID, number, c.DbClient)
+	if err != nil {
+		writeRevisionLookupError(w, number, err)
+		return
+	}
+
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		if err := applyRevision(tx, snippet, revision); err != nil {
+			return err
+		}
+		_, err := recordRevision(tx, snippet, user.ID, fmt.Sprintf("Restored revision %d", number))
+		return err
+	})
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to restore revision", Error: err.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: snippet, Message: "Revision restored successfully"})
+}
+
+func writeRevisionLookupError(w http.ResponseWriter, number int, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: fmt.Sprintf("Revision %d not found", number), Error: err.Error()})
+		return
+	}
+	utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "An error occurred", Error: err.Error()})
+}
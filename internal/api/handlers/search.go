@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/internal/services/auth"
+	"code-garden-server/utils"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SearchSnippets does a full-text search over the name, tags and code of
+// public snippets, the user's own and their organizations'.
+//
+// Query parameters: q, language, tag, owner, createdAfter and createdBefore
+// (dates or RFC 3339 times), sort (relevance, recent, forks or stars), order,
+// cursor, offset and limit.
+func (c *CodeHandler) SearchSnippets(w http.ResponseWriter, r *http.Request) {
+	c.writeSearchResults(w, r, "", "relevance")
+}
+
+// writeSearchResults responds with a page of the search of the request's query
+// string, limited to the snippets with the tag unless it is empty.
+func (c *CodeHandler) writeSearchResults(w http.ResponseWriter, r *http.Request, tag string, defaultSort string) {
+	params, err := searchParamsFromQuery(r)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	if tag != "" {
+		params.Tag = tag
+	}
+
+	pageParams, err := pageParamsFromQuery(r, queries.SearchSorts(params.Query), defaultSort)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	page, err := queries.SearchSnippets(params, pageParams, c.DbClient)
+	if err != nil {
+		writePageError(w, err, "Failed to search snippets")
+		return
+	}
+
+	writePage(w, "snippets", page, "Success")
+}
+
+// searchParamsFromQuery reads the search filters of the request's query string.
+func searchParamsFromQuery(r *http.Request) (queries.SearchParams, error) {
+	query := r.URL.Query()
+	user := auth.GetUser(r)
+
+	params := queries.SearchParams{
+		UserId:   user.ID,
+		Query:    query.Get("q"),
+		Language: query.Get("language"),
+		Tag:      query.Get("tag"),
+	}
+
+	if v := query.Get("owner"); v != "" {
+		ownerId, err := uuid.Parse(v)
+		if err != nil {
+			return params, errors.New("owner must be a user id")
+		}
+		params.OwnerId = &ownerId
+	}
+
+	var err error
+	if params.CreatedAfter, err = parseTimeParam(query.Get("createdAfter")); err != nil {
+		return params, err
+	}
+	if params.CreatedBefore, err = parseTimeParam(query.Get("createdBefore")); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+// parseTimeParam parses a date or an RFC 3339 time, an empty value is nil.
+func parseTimeParam(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid date %q", v)
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database"
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/internal/services/auth"
+	"code-garden-server/internal/services/docker"
+	"code-garden-server/utils"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DefaultShareLinkLifetime is how long share links last when no expiry is asked for.
+const DefaultShareLinkLifetime = time.Hour * 24 * 7
+
+// ShareHandler hands out signed links to snippets, which open them for anyone
+// holding the link whatever the snippet's visibility.
+type ShareHandler struct {
+	DbClient *database.DBClient
+	service  docker.Runner
+	limiter  *RunLimiter
+}
+
+// NewShareHandler creates a ShareHandler. Runs through share links take from
+// the same limiter as the other runs of anonymous callers.
+func NewShareHandler(dbClient *database.DBClient, runner docker.Runner, limiter *RunLimiter) *ShareHandler {
+	return &ShareHandler{DbClient: dbClient, service: runner, limiter: limiter}
+}
+
+// CreateShareLink signs a link to one of the user's snippets. expiresIn is in
+// seconds and the permission defaults to reading and running the snippet.
+func (h *ShareHandler) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	type createShareLinkRequestBody struct {
+		Permission auth.SharePermission `json:"permission"`
+		ExpiresIn  int64                `json:"expiresIn"`
+	}
+
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	var body createShareLinkRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	if body.Permission == "" {
+		body.Permission = auth.ShareReadRun
+	}
+	lifetime := DefaultShareLinkLifetime
+	if body.ExpiresIn != 0 {
+		lifetime = time.Duration(body.ExpiresIn) * time.Second
+	}
+
+	snippet, err := queries.GetOwnedSnippet(publicId, user.ID, h.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	expiresAt := time.Now().Add(lifetime)
+	token, err := auth.CreateShareLink(snippet, body.Permission, expiresAt)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidShareLink) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+			return
+		}
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to create share link", Error: err.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			"token":      token,
+			"permission": body.Permission,
+			"expiresAt":  expiresAt,
+		},
+		Status:  http.StatusCreated,
+		Message: "Share link created successfully",
+	})
+}
+
+// RevokeShareLinks stops every share link handed out for the snippet so far.
+func (h *ShareHandler) RevokeShareLinks(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	snippet, err := queries.GetOwnedSnippet(publicId, user.ID, h.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	tx := h.DbClient.Model(&models.Snippet{}).Where("id = ?", snippet.ID).UpdateColumn("share_version", gorm.Expr("share_version + 1"))
+	if tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to revoke share links", Error: tx.Error.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Message: "Share links revoked successfully"})
+}
+
+// getSharedSnippet returns the snippet a share link points at, with its files.
+func (h *ShareHandler) getSharedSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, *auth.ShareClaims, bool) {
+	claims, err := auth.ParseShareLink(r.PathValue("token"))
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusGone, Message: "Share link expired", Error: err.Error()})
+			return nil, nil, false
+		}
+		utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Share link not found", Error: err.Error()})
+		return nil, nil, false
+	}
+
+	var snippet models.Snippet
+	tx := h.DbClient.Preload("Owner").Preload("Files").Preload("Tags").First(&snippet, "public_id = ? AND share_version = ?", claims.PublicId, claims.Version)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Share link not found", Error: "the share link was revoked or its snippet deleted"})
+			return nil, nil, false
+		}
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "An error occurred", Error: tx.Error.Error()})
+		return nil, nil, false
+	}
+
+	return &snippet, claims, true
+}
+
+// GetSharedSnippet returns the snippet behind a share link. Run-only links
+// leave out the code and files so the snippet can be run but not read.
+func (h *ShareHandler) GetSharedSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet, claims, ok := h.getSharedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	if !claims.Permission.CanRead() {
+		snippet.Code, snippet.Output = "", ""
+		for i := range snippet.Files {
+			snippet.Files[i].Content = ""
+		}
+	}
+
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			"snippet":    snippet,
+			"permission": claims.Permission,
+			"expiresAt":  claims.ExpiresAt,
+		},
+		Status:  http.StatusOK,
+		Message: "Successfully retrieved code snippet",
+	})
+}
+
+// RunSharedSnippet runs the snippet behind a share link as it is, with the
+// stdin in the body if there is one. Anyone can hold a link, so runs are
+// limited like signed out runs.
+func (h *ShareHandler) RunSharedSnippet(w http.ResponseWriter, r *http.Request) {
+	type runSharedRequestBody struct {
+		Stdin *string `json:"stdin"`
+	}
+
+	var body runSharedRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	snippet, claims, ok := h.getSharedSnippet(w, r)
+	if !ok {
+		return
+	}
+	if !claims.Permission.CanRun() {
+		utils.WriteRes(w, utils.Response{Status: http.StatusForbidden, Message: "Forbidden", Error: "the share link doesn't allow running the snippet"})
+		return
+	}
+
+	rr := docker.RunRequest{
+		ID:         uuid.NewString(),
+		Language:   docker.Language(snippet.Language),
+		Code:       snippet.Code,
+		Stdin:      snippet.Stdin,
+		Args:       snippet.Args,
+		Files:      snippet.FileMap(),
+		Entrypoint: snippet.Entrypoint,
+	}
+	if body.Stdin != nil {
+		rr.Stdin = *body.Stdin
+	}
+
+	refund, ok := h.limiter.consume(w, r.RemoteAddr, REQUESTS_ALLOWED_PER_MINUTE_NO_AUTH)
+	if !ok {
+		return
+	}
+
+	res, err := h.service.RunLanguageContainer(r.Context(), rr)
+	if isRejectedRun(err) {
+		refund()
+	}
+	writeExecutionResult(w, res, err)
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/internal/services/auth"
+	"code-garden-server/utils"
+	"net/http"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StarSnippet stars a snippet for the user. Starring it again changes nothing.
+func (c *CodeHandler) StarSnippet(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		// the primary key makes concurrent stars of the same snippet by the same user conflict
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Star{UserId: user.ID, SnippetId: snippet.ID})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return tx.Model(&models.Snippet{}).Where("id = ?", snippet.ID).UpdateColumn("stars", gorm.Expr("stars + 1")).Error
+	})
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to star snippet", Error: err.Error()})
+		return
+	}
+
+	c.writeStarResult(w, snippet, true)
+}
+
+func (c *CodeHandler) UnstarSnippet(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	// like starring, so the star count of snippets the user can't see isn't leaked
+	snippet, err := queries.GetVisibleSnippet(publicId, user.ID, snippetPassword(r), c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("user_id = ? AND snippet_id = ?", user.ID, snippet.ID).Delete(&models.Star{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return tx.Model(&models.Snippet{}).Where("id = ?", snippet.ID).UpdateColumn("stars", gorm.Expr("GREATEST(stars - 1, 0)")).Error
+	})
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to unstar snippet", Error: err.Error()})
+		return
+	}
+
+	c.writeStarResult(w, snippet, false)
+}
+
+func (c *CodeHandler) writeStarResult(w http.ResponseWriter, snippet *models.Snippet, starred bool) {
+	var stars int
+	if err := c.DbClient.Model(&models.Snippet{}).Select("stars").Where("id = ?", snippet.ID).Scan(&stars).Error; err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "An error occurred", Error: err.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			"publicId":    snippet.PublicId,
+			"stars":       stars,
+			"starredByMe": starred,
+		},
+		Status:  http.StatusOK,
+		Message: "Success",
+	})
+}
+
+// GetStarredSnippets returns a page of the snippets the user starred, most recently
+// starred first unless sorted otherwise. Deleted snippets and ones that are no longer
+// visible to the user are left out, unlisted snippets stay since the user has their
+// link, password protected ones don't.
+func (c *CodeHandler) GetStarredSnippets(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	params, err := pageParamsFromQuery(r, starredSorts, "starred")
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	filter, err := snippetFilterFromQuery(r)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	tx := c.DbClient.Model(models.Snippet{}).
+		Joins("JOIN stars ON stars.snippet_id = snippets.id AND stars.user_id = ?", user.ID)
+	tx = queries.WhereSnippetVisible(tx, user.ID, models.VisibilityPublic, models.VisibilityUnlisted)
+
+	page, err := queries.PaginateSnippets(filter.Apply(tx), params, "Owner", "Tags")
+	if err != nil {
+		writePageError(w, err, "Failed to retrieve starred snippets")
+		return
+	}
+
+	for i := range page.Items {
+		page.Items[i].StarredByMe = true
+	}
+
+	writePage(w, "snippets", page, "Starred snippets retrieved successfully")
+}
+
+// starredSorts adds sorting by when the user starred a snippet to the snippet sorts.
+var starredSorts = withSnippetSorts(map[string]queries.SortKey{
+	"starred": {Expr: "stars.created_at", Type: "timestamptz", Desc: true},
+})
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/utils"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MaxTagsPerSnippet = 10
+	MaxTagLength      = 32
+)
+
+// normalizeTags turns tag names into tags with slugs, dropping duplicates.
+func normalizeTags(names []string) ([]models.Tag, error) {
+	tags := make([]models.Tag, 0, len(names))
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		slug := utils.Slugify(name)
+		if slug == "" {
+			return nil, fmt.Errorf("invalid tag %q", name)
+		}
+		if len(slug) > MaxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", name, MaxTagLength)
+		}
+		if seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		tags = append(tags, models.Tag{Name: name, Slug: slug})
+	}
+
+	if len(tags) > MaxTagsPerSnippet {
+		return nil, fmt.Errorf("a snippet can have at most %d tags", MaxTagsPerSnippet)
+	}
+	return tags, nil
+}
+
+// setSnippetTags replaces the tags of the snippet, creating the ones that don't exist yet.
+func setSnippetTags(tx *gorm.DB, snippet *models.Snippet, tags []models.Tag) error {
+	stored := []models.Tag{}
+	if len(tags) > 0 {
+		if err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "slug"}}, DoNothing: true}).Create(&tags).Error; err != nil {
+			return err
+		}
+
+		slugs := make([]string, len(tags))
+		for i, tag := range tags {
+			slugs[i] = tag.Slug
+		}
+		// tags that already existed keep their id and name
+		if err := tx.Where("slug IN ?", slugs).Order("slug").Find(&stored).Error; err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if len(stored) == 0 {
+		err = tx.Model(snippet).Association("Tags").Clear()
+	} else {
+		err = tx.Model(snippet).Association("Tags").Replace(stored)
+	}
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, len(stored))
+	for i, tag := range stored {
+		names[i] = tag.Name
+	}
+	snippet.Tags = stored
+	snippet.TagNames = strings.Join(names, " ")
+	return tx.Model(snippet).UpdateColumn("tag_names", snippet.TagNames).Error
+}
+
+// tagSnippetCount counts the public snippets using a tag.
+const tagSnippetCount = "(SELECT count(*) FROM snippet_tags JOIN snippets ON snippets.id = snippet_tags.snippet_id AND snippets.deleted_at IS NULL AND snippets.visibility = 'public' WHERE snippet_tags.tag_id = tags.id)"
+
+var tagSorts = map[string]queries.SortKey{
+	"count": {Expr: tagSnippetCount, Type: "bigint", Desc: true},
+	"name":  {Expr: "tags.slug", Type: "text"},
+}
+
+type tagCount struct {
+	ID    uuid.UUID `json:"-"`
+	Name  string    `json:"name"`
+	Slug  string    `json:"slug"`
+	Count int64     `json:"count"`
+}
+
+// ListTags returns a page of the tags used by public snippets with the number of
+// them using it, most used first unless sorted otherwise.
+func (c *CodeHandler) ListTags(w http.ResponseWriter, r *http.Request) {
+	params, err := pageParamsFromQuery(r, tagSorts, "count")
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	tx := c.DbClient.Model(&models.Tag{}).Where(tagSnippetCount + " > 0")
+
+	load := func(ids []uuid.UUID) ([]tagCount, error) {
+		var tags []tagCount
+		err := c.DbClient.Model(&models.Tag{}).
+			Select("tags.id, tags.name, tags.slug, "+tagSnippetCount+" AS count").
+			Where("tags.id IN ?", ids).
+			Scan(&tags).Error
+		return tags, err
+	}
+
+	page, err := queries.Paginate(tx, "tags", params, load, func(t tagCount) uuid.UUID { return t.ID })
+	if err != nil {
+		writePageError(w, err, "Failed to retrieve tags")
+		return
+	}
+
+	writePage(w, "tags", page, "Tags retrieved successfully")
+}
+
+// GetTagSnippets lists the snippets with a tag, newest first. It takes the same
+// query parameters as SearchSnippets.
+func (c *CodeHandler) GetTagSnippets(w http.ResponseWriter, r *http.Request) {
+	c.writeSearchResults(w, r, utils.Slugify(r.PathValue("slug")), "recent")
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/internal/services/auth"
+	"code-garden-server/utils"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TransferLifetime is how long the recipient of a transfer has to accept it.
+const TransferLifetime = time.Hour * 24 * 7
+
+var errTransferNotFound = errors.New("transfer not found")
+
+// transferEmail is the data of the transfer notification templates.
+type transferEmail struct {
+	ClientHost  string
+	SnippetName string
+	PublicId    string
+	Token       string
+	FromEmail   string
+	ToEmail     string
+	Status      models.TransferStatus
+}
+
+// cancelPendingTransfers cancels the pending transfers of a snippet and expires their tokens.
+func cancelPendingTransfers(tx *gorm.DB, snippetId uuid.UUID) error {
+	var pending []models.SnippetTransfer
+	if err := tx.Preload("Token").Find(&pending, "snippet_id = ? AND status = ?", snippetId, models.TransferPending).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, transfer := range pending {
+		if err := transfer.Token.Expire(tx); err != nil {
+			return err
+		}
+		if err := tx.Model(&transfer).Updates(map[string]interface{}{"status": models.TransferCancelled, "resolved_at": now}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TransferSnippet offers one of the user's snippets to the user with the given
+// email, who gets a token to accept it with. Starting a transfer cancels the
+// one still pending for the snippet.
+func (c *CodeHandler) TransferSnippet(w http.ResponseWriter, r *http.Request) {
+	type transferRequestBody struct {
+		Email      string `json:"email"`
+		ClientHost string `json:"clientHost"`
+	}
+
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	var body transferRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	snippet, err := queries.GetOwnedSnippet(publicId, user.ID, c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+	if snippet.OrganizationId != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: "snippets of an organization can't be transferred, move it out of the organization first"})
+		return
+	}
+
+	recipient, err := queries.GetUserFromEmail(strings.TrimSpace(body.Email), c.DbClient)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "User not found", Error: "no user has that email"})
+			return
+		}
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "An error occurred", Error: err.Error()})
+		return
+	}
+	if recipient.ID == user.ID {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: "you already own the snippet"})
+		return
+	}
+
+	transfer := models.SnippetTransfer{SnippetId: snippet.ID, FromId: user.ID, ToId: recipient.ID, Status: models.TransferPending}
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		if err := cancelPendingTransfers(tx, snippet.ID); err != nil {
+			return err
+		}
+
+		transfer.Token = models.VerificationToken{Purpose: models.TokenPurposeTransfer, ExpiresAt: time.Now().Add(TransferLifetime), UserID: recipient.ID}
+		if err := tx.Create(&transfer.Token).Error; err != nil {
+			return err
+		}
+		transfer.TokenId = transfer.Token.ID
+		return tx.Omit("Snippet", "From", "To", "Token").Create(&transfer).Error
+	})
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to start transfer", Error: err.Error()})
+		return
+	}
+
+	notify([]string{recipient.Email}, user.Email+" wants to give you a snippet", "snippet-transfer", transferEmail{
+		ClientHost:  body.ClientHost,
+		SnippetName: snippet.Name,
+		PublicId:    snippet.PublicId,
+		Token:       transfer.Token.Token,
+		FromEmail:   user.Email,
+		ToEmail:     recipient.Email,
+	})
+
+	transfer.Snippet, transfer.From, transfer.To = *snippet, *user, *recipient
+	utils.WriteRes(w, utils.Response{Status: http.StatusCreated, Data: transfer, Message: "Transfer started successfully"})
+}
+
+// CancelTransfer cancels the pending transfer of one of the user's snippets.
+func (c *CodeHandler) CancelTransfer(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	snippet, err := queries.GetOwnedSnippet(publicId, user.ID, c.DbClient)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		return cancelPendingTransfers(tx, snippet.ID)
+	})
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to cancel transfer", Error: err.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Message: "Transfer cancelled"})
+}
+
+// GetIncomingTransfers returns the pending transfers of snippets to the user.
+func (c *CodeHandler) GetIncomingTransfers(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	var transfers []models.SnippetTransfer
+	tx := c.DbClient.Preload("Snippet").Preload("From").
+		Joins("JOIN verification_tokens ON verification_tokens.id = snippet_transfers.token_id AND verification_tokens.expired = false AND verification_tokens.expires_at > ?", time.Now()).
+		Where("snippet_transfers.to_id = ? AND snippet_transfers.status = ?", user.ID, models.TransferPending).
+		Order("snippet_transfers.created_at DESC").
+		Find(&transfers)
+	if tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to retrieve transfers", Error: tx.Error.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			"transfers": transfers,
+			"total":     len(transfers),
+		},
+		Status:  http.StatusOK,
+		Message: "Transfers retrieved successfully",
+	})
+}
+
+// AcceptTransfer makes the user the owner of the snippet of a transfer. The
+// snippet keeps its revisions, forks, stars and everything else, but share
+// links handed out by the previous owner stop working.
+func (c *CodeHandler) AcceptTransfer(w http.ResponseWriter, r *http.Request) {
+	c.resolveTransfer(w, r, models.TransferAccepted)
+}
+
+func (c *CodeHandler) DeclineTransfer(w http.ResponseWriter, r *http.Request) {
+	c.resolveTransfer(w, r, models.TransferDeclined)
+}
+
+// resolveTransfer accepts or declines the pending transfer of the token in the
+// path, which has to belong to the user.
+func (c *CodeHandler) resolveTransfer(w http.ResponseWriter, r *http.Request, status models.TransferStatus) {
+	type resolveTransferRequestBody struct {
+		ClientHost string `json:"clientHost"`
+	}
+
+	user := auth.GetUser(r)
+
+	var body resolveTransferRequestBody
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	var transfer models.SnippetTransfer
+	err := c.DbClient.Transaction(func(tx *gorm.DB) error {
+		err := tx.Preload("Token").Preload("From").
+			Joins("JOIN verification_tokens ON verification_tokens.id = snippet_transfers.token_id AND verification_tokens.purpose = ?", models.TokenPurposeTransfer).
+			First(&transfer, "verification_tokens.token = ? AND snippet_transfers.to_id = ? AND snippet_transfers.status = ?", r.PathValue("token"), user.ID, models.TransferPending).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errTransferNotFound
+			}
+			return err
+		}
+		if !transfer.Token.IsValid() {
+			return errTransferNotFound
+		}
+
+		if status == models.TransferAccepted {
+			// the snippet can't have changed hands or been deleted since the transfer started
+			res := tx.Model(&models.Snippet{}).Where("id = ? AND owner_id = ? AND organization_id IS NULL", transfer.SnippetId, transfer.FromId).
+				Updates(map[string]interface{}{"owner_id": user.ID, "share_version": gorm.Expr("share_version + 1")})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return errTransferNotFound
+			}
+		}
+
+		if err := transfer.Token.Expire(tx); err != nil {
+			return err
+		}
+		now := time.Now()
+		transfer.Status, transfer.ResolvedAt = status, &now
+		if err := tx.Model(&transfer).Updates(map[string]interface{}{"status": status, "resolved_at": now}).Error; err != nil {
+			return err
+		}
+		return tx.First(&transfer.Snippet, "id = ?", transfer.SnippetId).Error
+	})
+	if err != nil {
+		if errors.Is(err, errTransferNotFound) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Transfer not found", Error: "the transfer doesn't exist, expired or was cancelled"})
+			return
+		}
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to resolve transfer", Error: err.Error()})
+		return
+	}
+	transfer.To = *user
+
+	notify([]string{transfer.From.Email}, "Your snippet transfer was "+string(status), "snippet-transfer-resolved", transferEmail{
+		ClientHost:  body.ClientHost,
+		SnippetName: transfer.Snippet.Name,
+		PublicId:    transfer.Snippet.PublicId,
+		FromEmail:   transfer.From.Email,
+		ToEmail:     user.Email,
+		Status:      status,
+	})
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: transfer, Message: "Transfer " + string(status)})
+}
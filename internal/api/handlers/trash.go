@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/database/queries"
+	"code-garden-server/internal/services/auth"
+	"code-garden-server/internal/services/trash"
+	"code-garden-server/utils"
+	"errors"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// trashSorts adds sorting by deletion time to the snippet sorts.
+var trashSorts = withSnippetSorts(map[string]queries.SortKey{
+	"deleted": {Expr: "snippets.deleted_at", Type: "timestamptz", Desc: true},
+})
+
+// GetTrash returns a page of the deleted snippets of the user and of the
+// organizations they administer, most recently deleted first unless sorted
+// otherwise. Snippets are purged retentionDays after they were deleted.
+func (c *CodeHandler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	params, err := pageParamsFromQuery(r, trashSorts, "deleted")
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+	filter, err := snippetFilterFromQuery(r)
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusBadRequest, Message: "Bad request", Error: err.Error()})
+		return
+	}
+
+	tx := c.DbClient.Unscoped().Model(models.Snippet{}).Where("snippets.deleted_at IS NOT NULL")
+	tx = queries.WhereSnippetRole(tx, user.ID, models.RoleAdmin)
+
+	page, err := queries.PaginateSnippets(filter.Apply(tx), params, "Organization", "Tags")
+	if err != nil {
+		writePageError(w, err, "Failed to retrieve trash")
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{
+		Data: map[string]interface{}{
+			"snippets":      page.Items,
+			"total":         page.Total,
+			"nextCursor":    page.NextCursor,
+			"retentionDays": int(trash.Retention() / (24 * time.Hour)),
+		},
+		Status:  http.StatusOK,
+		Message: "Trash retrieved successfully",
+	})
+}
+
+// getTrashedSnippet returns a deleted snippet of the user or of an organization they administer.
+func (c *CodeHandler) getTrashedSnippet(publicId string, user *models.User) (*models.Snippet, error) {
+	var snippet models.Snippet
+	tx := queries.WhereSnippetRole(c.DbClient.Unscoped(), user.ID, models.RoleAdmin).
+		First(&snippet, "snippets.public_id = ? AND snippets.deleted_at IS NOT NULL", publicId)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &snippet, nil
+}
+
+// RestoreSnippet takes a snippet out of the trash.
+func (c *CodeHandler) RestoreSnippet(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	snippet, err := c.getTrashedSnippet(publicId, user)
+	if err != nil {
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	if tx := c.DbClient.Unscoped().Model(snippet).Update("deleted_at", nil); tx.Error != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to restore snippet", Error: tx.Error.Error()})
+		return
+	}
+	snippet.DeletedAt = gorm.DeletedAt{}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Data: snippet, Message: "Snippet restored successfully"})
+}
+
+// PurgeSnippet permanently deletes a snippet in the trash, there is no undoing it.
+func (c *CodeHandler) PurgeSnippet(w http.ResponseWriter, r *http.Request) {
+	publicId := r.PathValue("publicId")
+	user := auth.GetUser(r)
+
+	snippet, err := c.getTrashedSnippet(publicId, user)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.WriteRes(w, utils.Response{Status: http.StatusNotFound, Message: "Snippet not in trash", Error: "only snippets in the trash can be deleted permanently"})
+			return
+		}
+		writeSnippetLookupError(w, publicId, err)
+		return
+	}
+
+	err = c.DbClient.Transaction(func(tx *gorm.DB) error {
+		return trash.PurgeSnippet(tx, snippet.ID)
+	})
+	if err != nil {
+		utils.WriteRes(w, utils.Response{Status: http.StatusInternalServerError, Message: "Failed to delete snippet", Error: err.Error()})
+		return
+	}
+
+	utils.WriteRes(w, utils.Response{Status: http.StatusOK, Message: "Snippet deleted permanently"})
+}
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -124,10 +125,28 @@ func NewAuthMiddleware(s *Server) Middleware {
 	}
 }
 
+// NewAdminMiddleware only lets through users whose email is listed in the
+// comma separated ADMIN_EMAILS. It has to run after the auth middleware.
+func NewAdminMiddleware() Middleware {
+	handler := func(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, bool) {
+		user := auth.GetUser(r)
+		for _, email := range strings.Split(config.GetEnv("ADMIN_EMAILS"), ",") {
+			if email = strings.TrimSpace(email); email != "" && strings.EqualFold(email, user.Email) {
+				return w, r, true
+			}
+		}
+
+		utils.WriteRes(w, utils.Response{Status: http.StatusForbidden, Message: "Forbidden", Error: "admin access required"})
+		return w, r, false
+	}
+
+	return Middleware{Handler: handler}
+}
+
 func setCorsHeaders(w http.ResponseWriter, isOptions bool) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Snippet-Password")
 
 	if isOptions {
 		w.WriteHeader(http.StatusNoContent)
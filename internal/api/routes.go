@@ -3,19 +3,26 @@ package api
 import (
 	"code-garden-server/internal/api/handlers"
 	"code-garden-server/internal/database"
+	"code-garden-server/internal/services/docker"
 	"net/http"
 	"time"
 
-	"github.com/docker/docker/client"
 	"github.com/redis/go-redis/v9"
 )
 
-func InitServer(p int, dc *client.Client, dbc *database.DBClient, rds *redis.Client) {
-	s := NewServer(p, dc, dbc, rds)
+// InitServer serves the API on port p. Code is run by runner, which is either
+// the docker service itself or a jobs.QueueRunner that leaves it to the workers.
+func InitServer(p int, runner docker.Runner, dbc *database.DBClient, rds *redis.Client) {
+	s := NewServer(p, dbc, rds)
+
+	// runs of anonymous callers through share links count towards the same limit as their other runs
+	runLimiter := handlers.NewRunLimiter()
 
 	codeHandler := handlers.NewCodeHandler(dbc, rds)
-	dockerHandler := handlers.NewDockerHandler(dc, dbc)
+	dockerHandler := handlers.NewDockerHandler(runner, rds, runLimiter)
 	authHandler := handlers.NewAuthHandler(dbc, rds)
+	judgeHandler := handlers.NewJudgeHandler(dbc, runner, runLimiter)
+	shareHandler := handlers.NewShareHandler(dbc, runner, runLimiter)
 
 	delayMiddleware := Middleware{
 		Handler: func(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, bool) {
@@ -27,6 +34,7 @@ func InitServer(p int, dc *client.Client, dbc *database.DBClient, rds *redis.Cli
 	corsMiddleware := NewCorsMiddleware(s)
 	loggerMiddleware := NewLoggerMiddleware()
 	authMiddleware := NewAuthMiddleware(s)
+	adminMiddleware := NewAdminMiddleware()
 
 	defaultRouter := s.DefaultRouter()
 
@@ -37,6 +45,7 @@ func InitServer(p int, dc *client.Client, dbc *database.DBClient, rds *redis.Cli
 	defaultRouter.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("OK"))
 	})
+	defaultRouter.Get("/languages", dockerHandler.ListLanguages)
 
 	// main app routes
 	appRouter := defaultRouter.Group("/")
@@ -45,8 +54,12 @@ func InitServer(p int, dc *client.Client, dbc *database.DBClient, rds *redis.Cli
 	// appRouter.Post("/run-unsafe", codeHandler.RunCodeUnsafe)
 	appRouter.Get("/hello", codeHandler.SayHello)
 	appRouter.Get("/containers", dockerHandler.ListContainers)
+	appRouter.Get("/containers/pool", dockerHandler.GetPoolStats)
 	appRouter.Post("/code-runner", dockerHandler.RunCodeSafe)
+	appRouter.Post("/code-runner/stream", dockerHandler.RunCodeStream)
+	appRouter.Delete("/code-runner/{runId}", dockerHandler.CancelRun)
 	appRouter.Post("/code-runner/no-auth", dockerHandler.RunCodeSafeNoAuth, &authMiddleware)
+	appRouter.Get("/jobs/{id}", dockerHandler.GetJob)
 
 	// snippets sharing and retrieving
 	appRouter.Post("/snippet/create", codeHandler.CreateCodeSnippet)
@@ -54,9 +67,96 @@ func InitServer(p int, dc *client.Client, dbc *database.DBClient, rds *redis.Cli
 	appRouter.Get("/snippet/{publicId}/no-auth", codeHandler.GetSnippetNoAuth, &authMiddleware)
 	appRouter.Put("/snippet/{publicId}", codeHandler.UpdateSnippet)
 	appRouter.Delete("/snippet/{publicId}", codeHandler.DeleteSnippet)
+	appRouter.Post("/snippet/{publicId}/restore", codeHandler.RestoreSnippet)
+	appRouter.Delete("/snippet/{publicId}/permanent", codeHandler.PurgeSnippet)
 	appRouter.Post("/snippet/{publicId}/fork", codeHandler.ForkSnippet)
+	appRouter.Get("/snippet/{publicId}/forks", codeHandler.ListForks)
+	appRouter.Get("/snippet/{publicId}/ancestry", codeHandler.GetSnippetAncestry)
+
+	// proposals from forks back to the original snippet
+	appRouter.Post("/snippet/{publicId}/proposals", codeHandler.CreateProposal)
+	appRouter.Get("/snippet/{publicId}/proposals", codeHandler.ListProposals)
+	appRouter.Get("/snippet/{publicId}/proposals/{id}", codeHandler.GetProposal)
+	appRouter.Post("/snippet/{publicId}/proposals/{id}/comments", codeHandler.CommentOnProposal)
+	appRouter.Post("/snippet/{publicId}/proposals/{id}/accept", codeHandler.AcceptProposal)
+	appRouter.Post("/snippet/{publicId}/proposals/{id}/reject", codeHandler.RejectProposal)
+
+	// share links, opening them doesn't need an account
+	appRouter.Post("/snippet/{publicId}/share-links", shareHandler.CreateShareLink)
+	appRouter.Delete("/snippet/{publicId}/share-links", shareHandler.RevokeShareLinks)
+	appRouter.Get("/share/{token}", shareHandler.GetSharedSnippet, &authMiddleware)
+	appRouter.Post("/share/{token}/run", shareHandler.RunSharedSnippet, &authMiddleware)
+
+	// ownership transfers
+	appRouter.Post("/snippet/{publicId}/transfer", codeHandler.TransferSnippet)
+	appRouter.Delete("/snippet/{publicId}/transfer", codeHandler.CancelTransfer)
+	appRouter.Get("/transfers/incoming", codeHandler.GetIncomingTransfers)
+	appRouter.Post("/transfers/{token}/accept", codeHandler.AcceptTransfer)
+	appRouter.Post("/transfers/{token}/decline", codeHandler.DeclineTransfer)
+
+	// comments
+	appRouter.Get("/snippet/{publicId}/comments", codeHandler.ListComments)
+	appRouter.Post("/snippet/{publicId}/comments", codeHandler.CreateComment)
+	appRouter.Get("/snippet/{publicId}/comments/{id}", codeHandler.GetComment)
+	appRouter.Put("/snippet/{publicId}/comments/{id}", codeHandler.UpdateComment)
+	appRouter.Delete("/snippet/{publicId}/comments/{id}", codeHandler.DeleteComment)
+
+	// revision history
+	appRouter.Get("/snippet/{publicId}/revisions", codeHandler.ListRevisions)
+	appRouter.Get("/snippet/{publicId}/revisions/diff", codeHandler.DiffRevisions)
+	appRouter.Get("/snippet/{publicId}/revisions/{number}", codeHandler.GetRevision)
+	appRouter.Post("/snippet/{publicId}/revisions/{number}/restore", codeHandler.RestoreRevision)
+
+	// test cases and judging
+	appRouter.Get("/snippet/{publicId}/test-cases", judgeHandler.ListTestCases)
+	appRouter.Post("/snippet/{publicId}/test-cases", judgeHandler.CreateTestCase)
+	appRouter.Put("/snippet/{publicId}/test-cases/{id}", judgeHandler.UpdateTestCase)
+	appRouter.Delete("/snippet/{publicId}/test-cases/{id}", judgeHandler.DeleteTestCase)
+	appRouter.Post("/snippet/{publicId}/judge", judgeHandler.JudgeSnippet)
 
 	appRouter.Get("/snippets/mine", codeHandler.GetUserSnippets)
+	appRouter.Get("/snippets/search", codeHandler.SearchSnippets)
+	appRouter.Get("/snippets/starred", codeHandler.GetStarredSnippets)
+	appRouter.Get("/snippets/trash", codeHandler.GetTrash)
+
+	// stars
+	appRouter.Post("/snippet/{publicId}/star", codeHandler.StarSnippet)
+	appRouter.Delete("/snippet/{publicId}/star", codeHandler.UnstarSnippet)
+
+	// collections
+	appRouter.Post("/collections", codeHandler.CreateCollection)
+	appRouter.Get("/collections/mine", codeHandler.GetUserCollections)
+	appRouter.Get("/collection/{publicId}", codeHandler.GetCollection)
+	appRouter.Get("/collection/{publicId}/no-auth", codeHandler.GetCollectionNoAuth, &authMiddleware)
+	appRouter.Put("/collection/{publicId}", codeHandler.UpdateCollection)
+	appRouter.Delete("/collection/{publicId}", codeHandler.DeleteCollection)
+	appRouter.Post("/collection/{publicId}/snippets", codeHandler.AddSnippetToCollection)
+	appRouter.Delete("/collection/{publicId}/snippets/{snippetId}", codeHandler.RemoveSnippetFromCollection)
+
+	// organizations, their members and invitations
+	appRouter.Post("/organizations", codeHandler.CreateOrganization)
+	appRouter.Get("/organizations/mine", codeHandler.GetUserOrganizations)
+	appRouter.Get("/organization/{slug}", codeHandler.GetOrganization)
+	appRouter.Put("/organization/{slug}", codeHandler.UpdateOrganization)
+	appRouter.Delete("/organization/{slug}", codeHandler.DeleteOrganization)
+	appRouter.Get("/organization/{slug}/snippets", codeHandler.GetOrganizationSnippets)
+	appRouter.Put("/organization/{slug}/members/{userId}", codeHandler.UpdateMember)
+	appRouter.Delete("/organization/{slug}/members/{userId}", codeHandler.RemoveMember)
+	appRouter.Get("/organization/{slug}/invitations", codeHandler.ListInvitations)
+	appRouter.Post("/organization/{slug}/invitations", codeHandler.InviteMember)
+	appRouter.Delete("/organization/{slug}/invitations/{id}", codeHandler.RevokeInvitation)
+	appRouter.Get("/invitations/incoming", codeHandler.GetIncomingInvitations)
+	appRouter.Post("/invitations/{token}/accept", codeHandler.AcceptInvitation)
+	appRouter.Post("/invitations/{token}/decline", codeHandler.DeclineInvitation)
+
+	// tags
+	appRouter.Get("/tags", codeHandler.ListTags)
+	appRouter.Get("/tags/{slug}/snippets", codeHandler.GetTagSnippets)
+
+	// admin routes
+	adminRouter := appRouter.Group("/admin", &adminMiddleware)
+	adminRouter.Get("/images", dockerHandler.GetBuildStatuses)
+	adminRouter.Post("/images/{language}/rebuild", dockerHandler.RebuildImage)
 
 	// authentication router
 	auth := defaultRouter.Group("auth")
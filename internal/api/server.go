@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"path/filepath"
 
-	"github.com/docker/docker/client"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -18,22 +17,20 @@ type Route struct {
 }
 
 type Server struct {
-	routes       []Route
-	Port         int
-	mux          *http.ServeMux
-	dockerClient *client.Client
-	db           *database.DBClient
-	rdc          *redis.Client
+	routes []Route
+	Port   int
+	mux    *http.ServeMux
+	db     *database.DBClient
+	rdc    *redis.Client
 }
 
 // NewServer creates a new server with the default values
-func NewServer(port int, dockerClient *client.Client, db *database.DBClient, rdc *redis.Client) *Server {
+func NewServer(port int, db *database.DBClient, rdc *redis.Client) *Server {
 	mux := http.NewServeMux()
 	return &Server{
 		[]Route{},
 		port,
 		mux,
-		dockerClient,
 		db,
 		rdc,
 	}
@@ -88,7 +85,8 @@ func newRouter(mux *http.ServeMux, path string) *Router {
 func (r *Router) Group(path string, middlewares ...*Middleware) *Router {
 	path = filepath.Join(r.path, path)
 	rout := newRouter(r.mux, path)
-	rout.middlewares = append(r.middlewares, middlewares...)
+	// copy so groups never share (and overwrite) the parent's backing array
+	rout.middlewares = append(append([]*Middleware{}, r.middlewares...), middlewares...)
 
 	for _, m := range rout.middlewares {
 		r.middlewareSet[m] = true
@@ -25,13 +25,80 @@ func NewDBClient() (*DBClient, error) {
 }
 
 func (db *DBClient) Setup() error {
+	for _, stmt := range preMigrateStatements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+
 	err := db.AutoMigrate(
 		models.Snippet{},
 		models.User{},
 		models.VerificationToken{},
+		models.TestCase{},
+		models.SnippetFile{},
+		models.SnippetRevision{},
+		models.Tag{},
+		models.Star{},
+		models.SnippetProposal{},
+		models.ProposalComment{},
+		models.Comment{},
+		models.Collection{},
+		models.CollectionSnippet{},
+		models.SnippetTransfer{},
+		models.Organization{},
+		models.Membership{},
+		models.OrganizationInvitation{},
 	)
 	if err != nil {
 		return err
 	}
+
+	for _, stmt := range setupStatements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// preMigrateStatements prepare existing data for AutoMigrate. Like
+// setupStatements they run on every start and have to be idempotent.
+var preMigrateStatements = []string{
+	// visibility used to be free-form and nullable, anything that isn't a known
+	// visibility was only ever visible to its owner
+	`DO $$ BEGIN
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'snippets' AND column_name = 'visibility'
+		) THEN
+			UPDATE snippets SET visibility = 'private'
+			WHERE visibility IS NULL OR visibility NOT IN ('private', 'unlisted', 'public', 'password');
+		END IF;
+	END $$`,
+}
+
+// setupStatements migrate what AutoMigrate can't express. They run on every
+// start, so each of them has to be idempotent.
+var setupStatements = []string{
+	// search_vector used to be generated without tags, regenerate it with them
+	`DO $$ BEGIN
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'snippets' AND column_name = 'search_vector' AND generation_expression NOT LIKE '%tag_names%'
+		) THEN
+			ALTER TABLE snippets DROP COLUMN search_vector;
+		END IF;
+	END $$`,
+	// full-text search over snippets, names weigh more than tags and tags more than code
+	`ALTER TABLE snippets ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+		setweight(to_tsvector('simple', coalesce(tag_names, '')), 'B') ||
+		setweight(to_tsvector('simple', coalesce(code, '')), 'C')
+	) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_snippets_search_vector ON snippets USING GIN (search_vector)`,
+	// tokens from before purposes all defaulted to login, the ones sent with a
+	// transfer are only good for accepting it
+	`UPDATE verification_tokens SET purpose = 'transfer'
+	WHERE purpose <> 'transfer' AND id IN (SELECT token_id FROM snippet_transfers)`,
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"code-garden-server/utils"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,3 +20,26 @@ func (b *BaseModel) BeforeCreate(tx *gorm.DB) error {
 	b.ID = uuid.New()
 	return nil
 }
+
+// maxPublicIdAttempts bounds how many random public ids newPublicId tries.
+const maxPublicIdAttempts = 5
+
+// newPublicId returns a random public id that no row of model has. Deleted rows
+// keep their public id so they can be restored, so they're checked too.
+func newPublicId(tx *gorm.DB, model interface{}) (string, error) {
+	for i := 0; i < maxPublicIdAttempts; i++ {
+		publicId, err := utils.GenerateRandomString(8)
+		if err != nil {
+			return "", err
+		}
+
+		var count int64
+		if err := tx.Session(&gorm.Session{NewDB: true}).Unscoped().Model(model).Where("public_id = ?", publicId).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return publicId, nil
+		}
+	}
+	return "", errors.New("failed to generate a unique public id")
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Collection is a folder a user organizes snippets in. Collections nest through
+// ParentId and are ordered by Position among their siblings.
+type Collection struct {
+	BaseModel
+	PublicId    string     `json:"publicId" gorm:"unique"`
+	OwnerId     uuid.UUID  `json:"ownerId" gorm:"not null;index"`
+	Owner       *User      `json:"owner,omitempty"`
+	ParentId    *uuid.UUID `json:"parentId" gorm:"type:uuid;index"`
+	Name        string     `json:"name" gorm:"not null"`
+	Description string     `json:"description"`
+	// Visibility works like a snippet's, except collections can't have a password
+	Visibility Visibility `json:"visibility" gorm:"not null;default:private"`
+	Position   int        `json:"position"`
+
+	Children []Collection `json:"children,omitempty" gorm:"foreignKey:ParentId"`
+}
+
+// CollectionSnippet puts a snippet in a collection, at Position within it.
+type CollectionSnippet struct {
+	CollectionId uuid.UUID `json:"collectionId" gorm:"primaryKey;type:uuid"`
+	SnippetId    uuid.UUID `json:"snippetId" gorm:"primaryKey;type:uuid;index"`
+	Position     int       `json:"position"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// BeforeCreate hook
+func (c *Collection) BeforeCreate(tx *gorm.DB) error {
+	err := c.BaseModel.BeforeCreate(tx)
+	if err != nil {
+		return err
+	}
+
+	publicId, err := newPublicId(tx, &Collection{})
+	if err != nil {
+		return err
+	}
+
+	c.PublicId = publicId
+	return nil
+}
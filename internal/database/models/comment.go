@@ -0,0 +1,48 @@
+package models
+
+import (
+	"code-garden-server/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Comment is a markdown comment on a snippet. Replies point at the top level
+// comment of their thread through ParentId, threads are one level deep. Body
+// is the markdown as written, clients show BodyHTML or sanitize Body themselves.
+type Comment struct {
+	BaseModel
+	SnippetId uuid.UUID  `json:"-" gorm:"not null;index"`
+	Snippet   Snippet    `json:"-"`
+	AuthorId  uuid.UUID  `json:"authorId" gorm:"not null"`
+	Author    User       `json:"author" gorm:"foreignKey:AuthorId"`
+	ParentId  *uuid.UUID `json:"parentId" gorm:"index"`
+	Body      string     `json:"body" gorm:"not null"`
+	BodyHTML  string     `json:"bodyHtml" gorm:"-"`
+
+	// a top level comment can be anchored to lines LineStart to LineEnd of a
+	// file of a revision, Path is empty for single-file snippets
+	Revision  int    `json:"revision,omitempty"`
+	Path      string `json:"path,omitempty"`
+	LineStart int    `json:"lineStart,omitempty"`
+	LineEnd   int    `json:"lineEnd,omitempty"`
+
+	Replies []Comment `json:"replies,omitempty" gorm:"foreignKey:ParentId"`
+}
+
+// RenderBody sets BodyHTML from Body.
+func (c *Comment) RenderBody() {
+	c.BodyHTML = utils.RenderMarkdown(c.Body)
+}
+
+// AfterFind hook
+func (c *Comment) AfterFind(tx *gorm.DB) error {
+	c.RenderBody()
+	return nil
+}
+
+// AfterCreate hook
+func (c *Comment) AfterCreate(tx *gorm.DB) error {
+	c.RenderBody()
+	return nil
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Role is what a member can do in an organization and with its snippets. The
+// owner of a personal snippet has RoleOwner on it.
+type Role string
+
+const (
+	// RoleOwner members can do everything, including deleting the organization
+	RoleOwner Role = "owner"
+	// RoleAdmin members manage members, invitations, sharing and deleting snippets
+	RoleAdmin Role = "admin"
+	// RoleEditor members create and edit snippets
+	RoleEditor Role = "editor"
+	// RoleViewer members open, run and fork snippets
+	RoleViewer Role = "viewer"
+)
+
+// roles from the least to the most privileged
+var roles = []Role{RoleViewer, RoleEditor, RoleAdmin, RoleOwner}
+
+func (r Role) rank() int {
+	for i, role := range roles {
+		if role == r {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (r Role) IsValid() bool {
+	return r.rank() > 0
+}
+
+// AtLeast reports whether r allows everything role does. No role allows nothing.
+func (r Role) AtLeast(role Role) bool {
+	return r.IsValid() && r.rank() >= role.rank()
+}
+
+// AndAbove returns r and the roles more privileged than it.
+func (r Role) AndAbove() []Role {
+	if !r.IsValid() {
+		return nil
+	}
+	return roles[r.rank()-1:]
+}
+
+var ErrInsufficientRole = errors.New("your role doesn't allow this")
+
+// Organization is a team whose members share its snippets according to their Role.
+type Organization struct {
+	BaseModel
+	Name        string `json:"name" gorm:"not null"`
+	Slug        string `json:"slug" gorm:"unique;not null"`
+	Description string `json:"description"`
+
+	Members []Membership `json:"members,omitempty"`
+	// Role is the role of the user the organization was loaded for
+	Role Role `json:"role,omitempty" gorm:"-"`
+}
+
+// Membership makes a user a member of an organization. A user is a member of an
+// organization at most once.
+type Membership struct {
+	OrganizationId uuid.UUID     `json:"organizationId" gorm:"primaryKey;type:uuid"`
+	Organization   *Organization `json:"organization,omitempty"`
+	UserId         uuid.UUID     `json:"userId" gorm:"primaryKey;type:uuid;index"`
+	User           *User         `json:"user,omitempty"`
+	Role           Role          `json:"role" gorm:"not null"`
+	CreatedAt      time.Time     `json:"createdAt"`
+}
+
+type InvitationStatus string
+
+const (
+	InvitationPending  InvitationStatus = "pending"
+	InvitationAccepted InvitationStatus = "accepted"
+	InvitationDeclined InvitationStatus = "declined"
+	InvitationRevoked  InvitationStatus = "revoked"
+)
+
+// OrganizationInvitation invites whoever has Email to join an organization with
+// Role. The invitee doesn't need an account yet, they accept it with Token once
+// they signed up with that email.
+type OrganizationInvitation struct {
+	BaseModel
+	OrganizationId uuid.UUID        `json:"organizationId" gorm:"not null;index"`
+	Organization   Organization     `json:"organization"`
+	Email          string           `json:"email" gorm:"not null;index"`
+	Role           Role             `json:"role" gorm:"not null"`
+	InvitedById    uuid.UUID        `json:"invitedById" gorm:"not null"`
+	InvitedBy      User             `json:"invitedBy" gorm:"foreignKey:InvitedById"`
+	Token          string           `json:"-" gorm:"unique;not null"`
+	ExpiresAt      time.Time        `json:"expiresAt"`
+	Status         InvitationStatus `json:"status" gorm:"not null;default:pending;index"`
+	// ResolvedAt is when the invitation stopped being pending
+	ResolvedAt *time.Time `json:"resolvedAt"`
+}
+
+func (i *OrganizationInvitation) IsValid() bool {
+	return i.Status == InvitationPending && i.ExpiresAt.After(time.Now())
+}
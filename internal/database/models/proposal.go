@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ProposalStatus string
+
+const (
+	ProposalOpen     ProposalStatus = "open"
+	ProposalAccepted ProposalStatus = "accepted"
+	ProposalRejected ProposalStatus = "rejected"
+)
+
+// SnippetProposal proposes the program of a fork, at SourceRevision, as the next
+// revision of the snippet it was forked from.
+type SnippetProposal struct {
+	BaseModel
+	TargetId uuid.UUID `json:"targetId" gorm:"not null;index"`
+	Target   Snippet   `json:"-"`
+	SourceId uuid.UUID `json:"sourceId" gorm:"not null;index"`
+	Source   Snippet   `json:"-"`
+	AuthorId uuid.UUID `json:"authorId" gorm:"not null"`
+	Author   User      `json:"author" gorm:"foreignKey:AuthorId"`
+
+	Title       string `json:"title" gorm:"not null"`
+	Description string `json:"description"`
+	// BaseRevision is the revision of the target the fork was made from
+	BaseRevision   int            `json:"baseRevision"`
+	SourceRevision int            `json:"sourceRevision"`
+	Status         ProposalStatus `json:"status" gorm:"not null;default:open;index"`
+
+	ResolvedById *uuid.UUID `json:"resolvedById"`
+	ResolvedAt   *time.Time `json:"resolvedAt"`
+	// MergedRevision is the revision of the target an accepted proposal became
+	MergedRevision int `json:"mergedRevision,omitempty"`
+
+	Comments []ProposalComment `json:"comments,omitempty" gorm:"foreignKey:ProposalId"`
+}
+
+type ProposalComment struct {
+	BaseModel
+	ProposalId uuid.UUID `json:"proposalId" gorm:"not null;index"`
+	AuthorId   uuid.UUID `json:"authorId" gorm:"not null"`
+	Author     User      `json:"author" gorm:"foreignKey:AuthorId"`
+	Body       string    `json:"body" gorm:"not null"`
+}
@@ -1,23 +1,115 @@
 package models
 
 import (
-	"code-garden-server/utils"
-	"github.com/google/uuid"
+	"errors"
 
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+type Visibility string
+
+const (
+	// VisibilityPrivate snippets can only be opened by their owner
+	VisibilityPrivate Visibility = "private"
+	// VisibilityUnlisted snippets can be opened by anyone with their link but
+	// don't show up in search or any other listing
+	VisibilityUnlisted Visibility = "unlisted"
+	VisibilityPublic   Visibility = "public"
+	// VisibilityPassword snippets are unlisted snippets that also need their password
+	VisibilityPassword Visibility = "password"
+)
+
+func (v Visibility) IsValid() bool {
+	switch v {
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic, VisibilityPassword:
+		return true
+	}
+	return false
+}
+
+var (
+	ErrSnippetPasswordRequired = errors.New("the snippet is password protected")
+	ErrWrongSnippetPassword    = errors.New("wrong snippet password")
+)
+
 type Snippet struct {
 	BaseModel
-	Code       string    `json:"code"`
-	Language   string    `json:"language"`
-	Output     string    `json:"output"`
-	PublicId   string    `json:"publicId" gorm:"unique"`
-	Owner      User      `json:"owner"`
-	OwnerId    uuid.UUID `json:"ownerId" gorm:"not null"`
-	Name       string    `json:"name"`
-	Visibility string    `json:"visibility" gorm:"visibility default:private"`
-	Forks      int       `json:"forks"`
+	Code       string     `json:"code"`
+	Language   string     `json:"language"`
+	Output     string     `json:"output"`
+	PublicId   string     `json:"publicId" gorm:"unique"`
+	Owner      *User      `json:"owner,omitempty"`
+	OwnerId    uuid.UUID  `json:"ownerId" gorm:"not null"`
+	Name       string     `json:"name"`
+	Visibility Visibility `json:"visibility" gorm:"not null;default:private"`
+	Forks      int        `json:"forks"` // trashed forks count until they are purged
+	Stdin      string     `json:"stdin"`
+	Args       []string   `json:"args" gorm:"serializer:json"`
+	// OrganizationId makes the snippet belong to an organization instead of its
+	// owner, whose members can use it according to their Role
+	OrganizationId *uuid.UUID    `json:"organizationId" gorm:"type:uuid;index"`
+	Organization   *Organization `json:"organization,omitempty"`
+	// PasswordHash is the bcrypt hash of the password of VisibilityPassword snippets
+	PasswordHash string `json:"-"`
+	// ShareVersion is signed into share links, bumping it revokes every link
+	ShareVersion int `json:"-" gorm:"not null;default:0"`
+	// Files make the snippet a multi-file project, Code is unused then
+	Files      []SnippetFile `json:"files,omitempty" gorm:"foreignKey:SnippetId"`
+	Entrypoint string        `json:"entrypoint"`
+	// Revision is the number of the snippet's latest SnippetRevision
+	Revision int   `json:"revision"`
+	Tags     []Tag `json:"tags" gorm:"many2many:snippet_tags"`
+	// TagNames has the names of Tags separated by spaces, for full-text search
+	TagNames string `json:"-"`
+	// ForkedFromId is the snippet this one was forked from, at ForkedFromRevision
+	ForkedFromId       *uuid.UUID `json:"forkedFromId" gorm:"type:uuid;index"`
+	ForkedFrom         *Snippet   `json:"forkedFrom,omitempty" gorm:"foreignKey:ForkedFromId;constraint:OnDelete:SET NULL"`
+	ForkedFromRevision int        `json:"forkedFromRevision"`
+	// Stars counts the snippet's Star rows
+	Stars       int  `json:"stars" gorm:"not null;default:0"`
+	StarredByMe bool `json:"starredByMe" gorm:"-"`
+	// Role is the role of the user the snippet was loaded for, empty if they
+	// can only open it because of its visibility
+	Role Role `json:"role,omitempty" gorm:"-"`
+}
+
+// FileMap returns the snippet's files keyed by path.
+func (s *Snippet) FileMap() map[string]string {
+	if len(s.Files) == 0 {
+		return nil
+	}
+	files := make(map[string]string, len(s.Files))
+	for _, f := range s.Files {
+		files[f.Path] = f.Content
+	}
+	return files
+}
+
+// SetPassword hashes the password a VisibilityPassword snippet is opened with.
+func (s *Snippet) SetPassword(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	s.PasswordHash = string(hash)
+	return nil
+}
+
+// CheckPassword returns nil if a snippet found by its link can be opened with
+// the password, which only matters for password protected snippets.
+func (s *Snippet) CheckPassword(password string) error {
+	if s.Visibility != VisibilityPassword {
+		return nil
+	}
+	if password == "" {
+		return ErrSnippetPasswordRequired
+	}
+	if bcrypt.CompareHashAndPassword([]byte(s.PasswordHash), []byte(password)) != nil {
+		return ErrWrongSnippetPassword
+	}
+	return nil
 }
 
 // BeforeCreate hook
@@ -27,11 +119,11 @@ func (s *Snippet) BeforeCreate(tx *gorm.DB) error {
 		return err
 	}
 
-	randString, err := utils.GenerateRandomString(8)
+	publicId, err := newPublicId(tx, &Snippet{})
 	if err != nil {
 		return err
 	}
 
-	s.PublicId = randString
+	s.PublicId = publicId
 	return nil
 }
@@ -0,0 +1,12 @@
+package models
+
+import "github.com/google/uuid"
+
+// SnippetFile is one file of a multi-file snippet. Paths are relative to the
+// project root and unique within a snippet.
+type SnippetFile struct {
+	BaseModel
+	SnippetId uuid.UUID `json:"-" gorm:"not null;uniqueIndex:idx_snippet_file_path"`
+	Path      string    `json:"path" gorm:"not null;uniqueIndex:idx_snippet_file_path"`
+	Content   string    `json:"content"`
+}
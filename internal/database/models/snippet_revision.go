@@ -0,0 +1,30 @@
+package models
+
+import "github.com/google/uuid"
+
+// SnippetRevision is an immutable copy of a snippet's program, saved every time
+// it changes. Revisions are numbered from 1 within their snippet.
+type SnippetRevision struct {
+	BaseModel
+	SnippetId  uuid.UUID         `json:"-" gorm:"not null;uniqueIndex:idx_snippet_revision_number"`
+	Number     int               `json:"number" gorm:"not null;uniqueIndex:idx_snippet_revision_number"`
+	AuthorId   uuid.UUID         `json:"authorId" gorm:"not null"`
+	Author     User              `json:"author" gorm:"foreignKey:AuthorId"`
+	Message    string            `json:"message"`
+	Code       string            `json:"code"`
+	Language   string            `json:"language"`
+	Files      map[string]string `json:"files,omitempty" gorm:"serializer:json"`
+	Entrypoint string            `json:"entrypoint"`
+}
+
+// RevisionCodeFile is the path the code of single-file revisions is diffed under.
+const RevisionCodeFile = "code"
+
+// FileMap returns the revision's files keyed by path, with the code of a
+// single-file revision as RevisionCodeFile.
+func (sr *SnippetRevision) FileMap() map[string]string {
+	if len(sr.Files) > 0 {
+		return sr.Files
+	}
+	return map[string]string{RevisionCodeFile: sr.Code}
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Star is a user starring a snippet. A user stars a snippet at most once.
+type Star struct {
+	UserId    uuid.UUID `json:"userId" gorm:"primaryKey;type:uuid"`
+	SnippetId uuid.UUID `json:"snippetId" gorm:"primaryKey;type:uuid;index"`
+	CreatedAt time.Time `json:"createdAt"`
+}
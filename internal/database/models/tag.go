@@ -0,0 +1,9 @@
+package models
+
+// Tag categorizes snippets. Tags are identified by their slug, so "Go" and
+// "go" are the same tag.
+type Tag struct {
+	BaseModel
+	Name string `json:"name" gorm:"not null"`
+	Slug string `json:"slug" gorm:"unique;not null"`
+}
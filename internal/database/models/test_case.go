@@ -0,0 +1,19 @@
+package models
+
+import "github.com/google/uuid"
+
+// TestCase is an input and the output a snippet's program is expected to print
+// for it when the snippet is judged.
+type TestCase struct {
+	BaseModel
+	SnippetId      uuid.UUID `json:"snippetId" gorm:"not null;index"`
+	Snippet        Snippet   `json:"-"`
+	Position       int       `json:"position"`
+	Name           string    `json:"name"`
+	Stdin          string    `json:"stdin"`
+	ExpectedStdout string    `json:"expectedStdout"`
+	// Hidden cases only show their verdict to anyone but the snippet's owner
+	Hidden bool `json:"hidden"`
+	// TimeLimitMs caps the run phase of this case, 0 uses the language's timeout
+	TimeLimitMs int64 `json:"timeLimitMs"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TransferStatus string
+
+const (
+	TransferPending   TransferStatus = "pending"
+	TransferAccepted  TransferStatus = "accepted"
+	TransferDeclined  TransferStatus = "declined"
+	TransferCancelled TransferStatus = "cancelled"
+)
+
+// SnippetTransfer hands a snippet from its owner to another user, who accepts
+// it with the token of TokenId.
+type SnippetTransfer struct {
+	BaseModel
+	SnippetId uuid.UUID         `json:"-" gorm:"not null;index"`
+	Snippet   Snippet           `json:"snippet"`
+	FromId    uuid.UUID         `json:"fromId" gorm:"not null"`
+	From      User              `json:"from" gorm:"foreignKey:FromId"`
+	ToId      uuid.UUID         `json:"toId" gorm:"not null;index"`
+	To        User              `json:"to" gorm:"foreignKey:ToId"`
+	TokenId   uuid.UUID         `json:"-" gorm:"not null"`
+	Token     VerificationToken `json:"-" gorm:"foreignKey:TokenId"`
+	Status    TransferStatus    `json:"status" gorm:"not null;default:pending;index"`
+	// ResolvedAt is when the transfer stopped being pending
+	ResolvedAt *time.Time `json:"resolvedAt"`
+}
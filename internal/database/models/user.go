@@ -2,6 +2,7 @@ package models
 
 import (
 	"code-garden-server/utils"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,10 +19,30 @@ type User struct {
 	EmailVerifiedAt *time.Time `json:"emailVerifiedAt" gorm:"email_verified_at;nullable" redis:"emailVerifiedAt"`
 }
 
+// TokenPurpose is what a VerificationToken can be used for. A token is only
+// accepted where its purpose is, so a transfer token can't be used to log in.
+type TokenPurpose string
+
+const (
+	// TokenPurposeLogin verifies the email of a user and logs them in
+	TokenPurposeLogin         TokenPurpose = "login"
+	TokenPurposeResetPassword TokenPurpose = "reset_password"
+	TokenPurposeTransfer      TokenPurpose = "transfer"
+)
+
+func (p TokenPurpose) IsValid() bool {
+	switch p {
+	case TokenPurposeLogin, TokenPurposeResetPassword, TokenPurposeTransfer:
+		return true
+	}
+	return false
+}
+
 type VerificationToken struct {
 	BaseModel
-	Token     string    `gorm:"unique;not null"`
-	ExpiresAt time.Time `gorm:"expires_at"`
+	Token     string       `gorm:"unique;not null"`
+	Purpose   TokenPurpose `gorm:"not null;default:login"`
+	ExpiresAt time.Time    `gorm:"expires_at"`
 	Expired   bool
 	UserID    uuid.UUID
 	User      User `gorm:"foreignKey:UserID"`
@@ -33,6 +54,12 @@ func (vt *VerificationToken) BeforeCreate(tx *gorm.DB) error {
 		return err
 	}
 
+	// the column defaults to login for tokens from before purposes, new ones
+	// have to say what they are for
+	if !vt.Purpose.IsValid() {
+		return errors.New("verification token has no purpose")
+	}
+
 	randStr, err := utils.GenerateRandomString(10)
 	if err != nil {
 		return err
@@ -42,7 +69,7 @@ func (vt *VerificationToken) BeforeCreate(tx *gorm.DB) error {
 }
 
 func (vt *VerificationToken) IsValid() bool {
-	return vt.Expired || vt.ExpiresAt.After(time.Now())
+	return !vt.Expired && vt.ExpiresAt.After(time.Now())
 }
 
 func (vt *VerificationToken) Expire(db *gorm.DB) error {
@@ -0,0 +1,32 @@
+package queries
+
+import (
+	"code-garden-server/internal/database"
+	"code-garden-server/internal/database/models"
+
+	"github.com/google/uuid"
+)
+
+// GetVisibleCollection returns the collection if the user owns it or it can be opened by its link.
+func GetVisibleCollection(publicId string, userId uuid.UUID, db *database.DBClient) (*models.Collection, error) {
+	var collection models.Collection
+	tx := db.Model(models.Collection{}).Preload("Owner").First(&collection, "public_id = ? AND (owner_id = ? or visibility IN ?)", publicId, userId, []models.Visibility{models.VisibilityPublic, models.VisibilityUnlisted})
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return &collection, nil
+}
+
+// GetOwnedCollection returns the collection only if the user owns it.
+func GetOwnedCollection(publicId string, userId uuid.UUID, db *database.DBClient) (*models.Collection, error) {
+	var collection models.Collection
+	tx := db.Model(models.Collection{}).First(&collection, "public_id = ? AND owner_id = ?", publicId, userId)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return &collection, nil
+}
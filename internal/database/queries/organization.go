@@ -0,0 +1,84 @@
+package queries
+
+import (
+	"code-garden-server/internal/database"
+	"code-garden-server/internal/database/models"
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GetMembership returns the membership of the user in the organization.
+func GetMembership(organizationId, userId uuid.UUID, db *gorm.DB) (*models.Membership, error) {
+	var membership models.Membership
+	tx := db.Model(models.Membership{}).First(&membership, "organization_id = ? AND user_id = ?", organizationId, userId)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return &membership, nil
+}
+
+// GetOrganizationWithRole returns the organization if the user is a member with
+// at least the role. Organizations the user isn't a member of aren't found.
+func GetOrganizationWithRole(slug string, userId uuid.UUID, role models.Role, db *database.DBClient) (*models.Organization, error) {
+	var organization models.Organization
+	tx := db.Model(models.Organization{}).First(&organization, "slug = ?", slug)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	membership, err := GetMembership(organization.ID, userId, db.DB)
+	if err != nil {
+		return nil, err
+	}
+	if !membership.Role.AtLeast(role) {
+		return nil, models.ErrInsufficientRole
+	}
+
+	organization.Role = membership.Role
+	return &organization, nil
+}
+
+// SnippetRole returns the role of the user on the snippet: RoleOwner on their
+// personal snippets and their membership's role on an organization's snippets.
+// It's empty if they have none.
+func SnippetRole(snippet *models.Snippet, userId uuid.UUID, db *gorm.DB) (models.Role, error) {
+	if snippet.OrganizationId == nil {
+		if snippet.OwnerId == userId {
+			return models.RoleOwner, nil
+		}
+		return "", nil
+	}
+
+	membership, err := GetMembership(*snippet.OrganizationId, userId, db)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return membership.Role, nil
+}
+
+// snippetRoleExpr matches the snippets the user has at least the role on, like
+// SnippetRole. The owner of a snippet that belongs to an organization has no
+// role on it unless they're a member.
+func snippetRoleExpr(userId uuid.UUID, role models.Role) interface{} {
+	return gorm.Expr("((snippets.owner_id = ? AND snippets.organization_id IS NULL) OR snippets.organization_id IN (SELECT organization_id FROM memberships WHERE user_id = ? AND role IN ?))",
+		userId, userId, role.AndAbove())
+}
+
+// WhereSnippetRole limits tx to the snippets the user has at least the role on.
+func WhereSnippetRole(tx *gorm.DB, userId uuid.UUID, role models.Role) *gorm.DB {
+	return tx.Where("?", snippetRoleExpr(userId, role))
+}
+
+// WhereSnippetVisible limits tx to the snippets with one of the visibilities and
+// the ones the user has any role on.
+func WhereSnippetVisible(tx *gorm.DB, userId uuid.UUID, visibilities ...models.Visibility) *gorm.DB {
+	return tx.Where("(snippets.visibility IN ? OR ?)", visibilities, snippetRoleExpr(userId, models.RoleViewer))
+}
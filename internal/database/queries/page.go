@@ -0,0 +1,218 @@
+package queries
+
+import (
+	"code-garden-server/internal/database/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SortKey orders a list by an SQL expression, ties are broken by id.
+type SortKey struct {
+	// Expr must never be null
+	Expr string
+	// Args are bound to the placeholders of Expr
+	Args []interface{}
+	// Type is the SQL type of Expr, cursors keep its value as text and cast it back
+	Type string
+	Desc bool
+}
+
+// PageParams select a page of a list. A cursor takes precedence over an offset.
+type PageParams struct {
+	Sort   SortKey
+	Cursor string
+	Offset int
+	Limit  int
+}
+
+// Page is a page of a list, Total counts the whole list and NextCursor is empty
+// on the last page.
+type Page[T any] struct {
+	Items      []T
+	Total      int64
+	NextCursor string
+}
+
+// pageCursor points just past the last row of a page: the sort value and id of that row.
+type pageCursor struct {
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+func encodePageCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageCursor(s string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c pageCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// Paginate returns a page of the rows of tx, a filtered query on table. Only
+// the ids of the page are queried with tx, the rows themselves are loaded by
+// load so preloads don't interfere with the page query, and put back in order
+// with idOf.
+func Paginate[T any](tx *gorm.DB, table string, params PageParams, load func(ids []uuid.UUID) ([]T, error), idOf func(T) uuid.UUID) (*Page[T], error) {
+	if params.Limit <= 0 || params.Limit > MaxPageLimit {
+		params.Limit = DefaultPageLimit
+	}
+
+	page := &Page[T]{Items: []T{}}
+	if err := tx.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	idColumn := table + ".id"
+	direction, comparison := "ASC", ">"
+	if params.Sort.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	keysTx := tx.Session(&gorm.Session{}).
+		Select(fmt.Sprintf("%s AS id, (%s)::text AS sort_value", idColumn, params.Sort.Expr), params.Sort.Args...)
+	if params.Cursor != "" {
+		cursor, err := decodePageCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		args := append(append([]interface{}{}, params.Sort.Args...), cursor.Value, cursor.ID)
+		keysTx = keysTx.Where(fmt.Sprintf("(%s, %s) %s (CAST(? AS %s), ?)", params.Sort.Expr, idColumn, comparison, params.Sort.Type), args...)
+	} else if params.Offset > 0 {
+		keysTx = keysTx.Offset(params.Offset)
+	}
+
+	var keys []struct {
+		ID        uuid.UUID
+		SortValue string
+	}
+	// one extra row tells whether there is another page
+	err := keysTx.
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                fmt.Sprintf("%s %s, %s %s", params.Sort.Expr, direction, idColumn, direction),
+			Vars:               params.Sort.Args,
+			WithoutParentheses: true,
+		}}).
+		Limit(params.Limit + 1).
+		Scan(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) > params.Limit {
+		keys = keys[:params.Limit]
+		last := keys[len(keys)-1]
+		page.NextCursor = encodePageCursor(pageCursor{Value: last.SortValue, ID: last.ID})
+	}
+	if len(keys) == 0 {
+		return page, nil
+	}
+
+	ids := make([]uuid.UUID, len(keys))
+	for i, key := range keys {
+		ids[i] = key.ID
+	}
+
+	found, err := load(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// put the rows back in the order of the page
+	byId := make(map[uuid.UUID]T, len(found))
+	for _, row := range found {
+		byId[idOf(row)] = row
+	}
+	for _, id := range ids {
+		if row, ok := byId[id]; ok {
+			page.Items = append(page.Items, row)
+		}
+	}
+
+	return page, nil
+}
+
+// SnippetSorts are the sort keys of snippet lists by name.
+var SnippetSorts = map[string]SortKey{
+	"recent":  {Expr: "snippets.created_at", Type: "timestamptz", Desc: true},
+	"updated": {Expr: "snippets.updated_at", Type: "timestamptz", Desc: true},
+	"name":    {Expr: "coalesce(snippets.name, '')", Type: "text"},
+	"stars":   {Expr: "snippets.stars", Type: "bigint", Desc: true},
+	"forks":   {Expr: "coalesce(snippets.forks, 0)", Type: "bigint", Desc: true},
+}
+
+// SnippetFilter narrows down a snippet list, zero fields don't filter.
+type SnippetFilter struct {
+	Language      string
+	Visibility    string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+func (f SnippetFilter) Apply(tx *gorm.DB) *gorm.DB {
+	if f.Language != "" {
+		tx = tx.Where("snippets.language = ?", f.Language)
+	}
+	if f.Visibility != "" {
+		tx = tx.Where("snippets.visibility = ?", f.Visibility)
+	}
+	if f.CreatedAfter != nil {
+		tx = tx.Where("snippets.created_at >= ?", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		tx = tx.Where("snippets.created_at < ?", *f.CreatedBefore)
+	}
+	return tx
+}
+
+// PaginateModels returns a page of the rows of tx, a filtered query on the
+// table of the model T. The rows are loaded with scopes applied, e.g. to
+// preload their relations.
+func PaginateModels[T any](tx *gorm.DB, table string, params PageParams, idOf func(T) uuid.UUID, scopes ...func(*gorm.DB) *gorm.DB) (*Page[T], error) {
+	load := func(ids []uuid.UUID) ([]T, error) {
+		db := tx.Session(&gorm.Session{NewDB: true})
+		if tx.Statement.Unscoped {
+			db = db.Unscoped()
+		}
+		var rows []T
+		err := db.Scopes(scopes...).Find(&rows, "id IN ?", ids).Error
+		return rows, err
+	}
+	return Paginate(tx, table, params, load, idOf)
+}
+
+// Preloads is a scope preloading the relations.
+func Preloads(relations ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, relation := range relations {
+			db = db.Preload(relation)
+		}
+		return db
+	}
+}
+
+// PaginateSnippets returns a page of the snippets of tx with the given relations preloaded.
+func PaginateSnippets(tx *gorm.DB, params PageParams, preloads ...string) (*Page[models.Snippet], error) {
+	return PaginateModels(tx, "snippets", params, func(s models.Snippet) uuid.UUID { return s.ID }, Preloads(preloads...))
+}
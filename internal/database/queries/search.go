@@ -0,0 +1,69 @@
+package queries
+
+import (
+	"code-garden-server/internal/database"
+	"code-garden-server/internal/database/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SearchParams filters a snippet search. Only public snippets and the ones
+// UserId has a role on are ever returned.
+type SearchParams struct {
+	UserId        uuid.UUID
+	Query         string
+	Language      string
+	Tag           string // slug
+	OwnerId       *uuid.UUID
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// SearchSorts are the sort keys of a search for query by name. Results are
+// ordered by relevance to the query, or newest first without a query.
+func SearchSorts(query string) map[string]SortKey {
+	relevance := SnippetSorts["recent"]
+	if query != "" {
+		relevance = SortKey{
+			Expr: "ts_rank(snippets.search_vector, websearch_to_tsquery('simple', ?))",
+			Args: []interface{}{query},
+			Type: "real",
+			Desc: true,
+		}
+	}
+
+	return map[string]SortKey{
+		"relevance": relevance,
+		"recent":    SnippetSorts["recent"],
+		"forks":     SnippetSorts["forks"],
+		"stars":     SnippetSorts["stars"],
+	}
+}
+
+// SearchSnippets returns a page of the snippets matching params.
+func SearchSnippets(params SearchParams, page PageParams, db *database.DBClient) (*Page[models.Snippet], error) {
+	tx := db.Model(&models.Snippet{})
+	tx = WhereSnippetVisible(tx, params.UserId, models.VisibilityPublic)
+
+	if params.Query != "" {
+		tx = tx.Where("snippets.search_vector @@ websearch_to_tsquery('simple', ?)", params.Query)
+	}
+	if params.Language != "" {
+		tx = tx.Where("snippets.language = ?", params.Language)
+	}
+	if params.Tag != "" {
+		tx = tx.Where("EXISTS (SELECT 1 FROM snippet_tags JOIN tags ON tags.id = snippet_tags.tag_id WHERE snippet_tags.snippet_id = snippets.id AND tags.slug = ?)", params.Tag)
+	}
+	if params.OwnerId != nil {
+		tx = tx.Where("snippets.owner_id = ?", *params.OwnerId)
+	}
+	if params.CreatedAfter != nil {
+		tx = tx.Where("snippets.created_at >= ?", *params.CreatedAfter)
+	}
+	if params.CreatedBefore != nil {
+		tx = tx.Where("snippets.created_at < ?", *params.CreatedBefore)
+	}
+
+	return PaginateSnippets(tx, page, "Owner", "Tags")
+}
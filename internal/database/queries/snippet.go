@@ -0,0 +1,137 @@
+package queries
+
+import (
+	"code-garden-server/internal/database"
+	"code-garden-server/internal/database/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GetVisibleSnippet returns the snippet if the user has a role on it or it can
+// be opened by its link. Password protected snippets also need the right password
+// unless the user has a role on them.
+func GetVisibleSnippet(publicId string, userId uuid.UUID, password string, db *database.DBClient) (*models.Snippet, error) {
+	var snippet models.Snippet
+	tx := WhereSnippetVisible(db.Model(models.Snippet{}), userId, linkVisibilities...).First(&snippet, "snippets.public_id = ?", publicId)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	role, err := SnippetRole(&snippet, userId, db.DB)
+	if err != nil {
+		return nil, err
+	}
+	if role == "" {
+		if err := snippet.CheckPassword(password); err != nil {
+			return nil, err
+		}
+	}
+
+	snippet.Role = role
+	return &snippet, nil
+}
+
+// linkVisibilities are the visibilities of snippets anyone with the link can open.
+var linkVisibilities = []models.Visibility{models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPassword}
+
+// GetVisibleSnippetById returns the snippet by its id if the user has a role on
+// it or it is public. Knowing the id of a snippet isn't having its link, so
+// unlisted snippets aren't returned.
+func GetVisibleSnippetById(id uuid.UUID, userId uuid.UUID, db *database.DBClient) (*models.Snippet, error) {
+	var snippet models.Snippet
+	tx := WhereSnippetVisible(db.Model(models.Snippet{}), userId, models.VisibilityPublic).First(&snippet, "snippets.id = ?", id)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return &snippet, nil
+}
+
+// GetSnippetWithRole returns the snippet if the user has at least the role on
+// it. Snippets the user has no role on aren't found, for the others a lower
+// role is models.ErrInsufficientRole.
+func GetSnippetWithRole(publicId string, userId uuid.UUID, role models.Role, db *database.DBClient) (*models.Snippet, error) {
+	var snippet models.Snippet
+	tx := db.Model(models.Snippet{}).First(&snippet, "public_id = ?", publicId)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	userRole, err := SnippetRole(&snippet, userId, db.DB)
+	if err != nil {
+		return nil, err
+	}
+	if userRole == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if !userRole.AtLeast(role) {
+		return nil, models.ErrInsufficientRole
+	}
+
+	snippet.Role = userRole
+	return &snippet, nil
+}
+
+// GetOwnedSnippet returns the snippet if the user owns it or administers the
+// organization it belongs to.
+func GetOwnedSnippet(publicId string, userId uuid.UUID, db *database.DBClient) (*models.Snippet, error) {
+	return GetSnippetWithRole(publicId, userId, models.RoleAdmin, db)
+}
+
+// GetEditableSnippet returns the snippet if the user owns it or is at least an
+// editor of the organization it belongs to.
+func GetEditableSnippet(publicId string, userId uuid.UUID, db *database.DBClient) (*models.Snippet, error) {
+	return GetSnippetWithRole(publicId, userId, models.RoleEditor, db)
+}
+
+// GetSnippetTestCases returns the test cases of a snippet in order.
+func GetSnippetTestCases(snippetId uuid.UUID, db *database.DBClient) ([]models.TestCase, error) {
+	var testCases []models.TestCase
+	tx := db.Model(models.TestCase{}).Order("position, created_at").Find(&testCases, "snippet_id = ?", snippetId)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return testCases, nil
+}
+
+// GetSnippetFiles returns the files of a multi-file snippet ordered by path.
+func GetSnippetFiles(snippetId uuid.UUID, db *database.DBClient) ([]models.SnippetFile, error) {
+	var files []models.SnippetFile
+	tx := db.Model(models.SnippetFile{}).Order("path").Find(&files, "snippet_id = ?", snippetId)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return files, nil
+}
+
+// GetSnippetRevision returns a revision of a snippet by its number.
+func GetSnippetRevision(snippetId uuid.UUID, number int, db *database.DBClient) (*models.SnippetRevision, error) {
+	var revision models.SnippetRevision
+	tx := db.Model(models.SnippetRevision{}).Preload("Author").First(&revision, "snippet_id = ? AND number = ?", snippetId, number)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return &revision, nil
+}
+
+// IsStarredBy reports whether the user has starred the snippet.
+func IsStarredBy(snippetId, userId uuid.UUID, db *database.DBClient) (bool, error) {
+	var count int64
+	tx := db.Model(models.Star{}).Where("snippet_id = ? AND user_id = ?", snippetId, userId).Count(&count)
+
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+
+	return count > 0, nil
+}
@@ -16,9 +16,11 @@ func GetUserFromEmail(email string, db *database.DBClient) (*models.User, error)
 	return &user, nil
 }
 
-func GetTokenFromString(tokenString string, db *database.DBClient) (*models.VerificationToken, error) {
+// GetTokenFromString returns the token with the given string, as long as it's
+// meant for purpose.
+func GetTokenFromString(tokenString string, purpose models.TokenPurpose, db *database.DBClient) (*models.VerificationToken, error) {
 	var token models.VerificationToken
-	tx := db.Model(models.VerificationToken{}).Preload("User").First(&token, "token = ?", tokenString)
+	tx := db.Model(models.VerificationToken{}).Preload("User").First(&token, "token = ? AND purpose = ?", tokenString, purpose)
 
 	if tx.Error != nil {
 		return nil, tx.Error
@@ -7,6 +7,7 @@ type Entity int
 const (
 	UserEntity Entity = iota
 	VerificationToken
+	JobEntity
 )
 
 type CacheKey struct {
@@ -17,6 +18,7 @@ type CacheKey struct {
 var EntityToModelName = map[Entity]string{
 	UserEntity:        "User",
 	VerificationToken: "VerificationToken",
+	JobEntity:         "Job",
 }
 
 func (q CacheKey) String() string {
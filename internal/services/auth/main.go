@@ -70,6 +70,7 @@ func (as *Service) RegisterWithEmail(email, clientHost string) error {
 		}
 
 		token := models.VerificationToken{
+			Purpose:   models.TokenPurposeLogin,
 			ExpiresAt: time.Now().Add(time.Minute * 10),
 			UserID:    user.ID,
 		}
@@ -132,6 +133,7 @@ func (as *Service) LoginWithEmail(email, clientHost string) error {
 
 	// generate token
 	token := models.VerificationToken{
+		Purpose:   models.TokenPurposeLogin,
 		ExpiresAt: time.Now().Add(time.Minute * 10),
 		UserID:    user.ID,
 	}
@@ -178,7 +180,7 @@ func (as *Service) LoginWithEmail(email, clientHost string) error {
 }
 
 func (as *Service) VerifyUserEmail(token string) (*models.VerificationToken, error) {
-	t, err := queries.GetTokenFromString(token, as.db)
+	t, err := queries.GetTokenFromString(token, models.TokenPurposeLogin, as.db)
 	if err != nil {
 		return nil, err
 	}
@@ -283,6 +285,7 @@ func (as *Service) SendResetPasswordEmail(email, host string) error {
 	}
 
 	token := models.VerificationToken{
+		Purpose:   models.TokenPurposeResetPassword,
 		ExpiresAt: time.Now().Add(time.Minute * 10),
 		UserID:    user.ID,
 	}
@@ -335,7 +338,7 @@ func (as *Service) SendResetPasswordEmail(email, host string) error {
 
 func (as *Service) ResetUserPassword(token, newPassword string) error {
 	var t models.VerificationToken
-	db := as.db.Preload("User").Model(models.VerificationToken{}).First(&t, "token = ?", token)
+	db := as.db.Preload("User").Model(models.VerificationToken{}).First(&t, "token = ? AND purpose = ?", token, models.TokenPurposeResetPassword)
 	if db.Error != nil {
 		return db.Error
 	}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"code-garden-server/config"
+	"code-garden-server/internal/database/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type SharePermission string
+
+const (
+	// ShareReadRun links let the holder read the snippet and run it
+	ShareReadRun SharePermission = "read-run"
+	// ShareRead links only let the holder read the snippet
+	ShareRead SharePermission = "read"
+	// ShareRun links let the holder run the snippet without seeing its code
+	ShareRun SharePermission = "run"
+)
+
+func (p SharePermission) IsValid() bool {
+	return p == ShareReadRun || p == ShareRead || p == ShareRun
+}
+
+func (p SharePermission) CanRead() bool {
+	return p == ShareReadRun || p == ShareRead
+}
+
+func (p SharePermission) CanRun() bool {
+	return p == ShareReadRun || p == ShareRun
+}
+
+// MaxShareLinkLifetime is how far in the future a share link can expire.
+const MaxShareLinkLifetime = time.Hour * 24 * 30
+
+const shareLinkIssuer = "code-garden-server/share"
+
+var ErrInvalidShareLink = errors.New("invalid share link")
+
+// ShareClaims are signed into a share link. Version has to match the
+// snippet's ShareVersion for the link to still work.
+type ShareClaims struct {
+	jwt.RegisteredClaims
+	PublicId   string          `json:"publicId"`
+	Version    int             `json:"version"`
+	Permission SharePermission `json:"permission"`
+}
+
+// shareLinkKey derives the key share links are signed with from the JWT secret.
+// It must differ from the JWT secret itself, or a share link would be accepted
+// as a session token.
+func shareLinkKey() []byte {
+	mac := hmac.New(sha256.New, []byte(config.GetEnv("JWT_SECRET")))
+	mac.Write([]byte("share links"))
+	return mac.Sum(nil)
+}
+
+// CreateShareLink returns a signed token that opens the snippet with the given
+// permission until expiresAt, or until the snippet's share links are revoked.
+func CreateShareLink(snippet *models.Snippet, permission SharePermission, expiresAt time.Time) (string, error) {
+	if !permission.IsValid() {
+		return "", fmt.Errorf("%w: unknown permission %q", ErrInvalidShareLink, permission)
+	}
+	now := time.Now()
+	if !expiresAt.After(now) || expiresAt.Sub(now) > MaxShareLinkLifetime {
+		return "", fmt.Errorf("%w: share links have to expire within %s", ErrInvalidShareLink, MaxShareLinkLifetime)
+	}
+
+	claims := ShareClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    shareLinkIssuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		PublicId:   snippet.PublicId,
+		Version:    snippet.ShareVersion,
+		Permission: permission,
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(shareLinkKey())
+}
+
+// ParseShareLink verifies the signature and expiry of a share link token.
+// Expired links return an error wrapping jwt.ErrTokenExpired.
+func ParseShareLink(token string) (*ShareClaims, error) {
+	claims := new(ShareClaims)
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return shareLinkKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(shareLinkIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if !claims.Permission.IsValid() {
+		return nil, ErrInvalidShareLink
+	}
+	return claims, nil
+}
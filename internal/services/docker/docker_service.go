@@ -5,30 +5,49 @@ import (
 	"bytes"
 	"code-garden-server/internal/database"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/google/uuid"
 )
 
 type Service struct {
 	dockerClient   *client.Client
 	databaseClient *database.DBClient
+	pool           *containerPool
+	builds         *buildTracker
+
+	runsMu sync.Mutex
+	// runs holds the cancel func of every run in progress, keyed by run ID
+	runs map[string]context.CancelFunc
 }
 
-func NewDockerService(dc *client.Client, dbClient *database.DBClient) *Service {
-	s := &Service{dc, dbClient}
-	err := s.SetupClient()
-	if err != nil {
-		panic(err)
+// NewDockerService creates the service of a process with the given role, e.g.
+// "api" or "worker", which keeps its pool containers apart from the other
+// processes using the same docker daemon.
+func NewDockerService(dc *client.Client, dbClient *database.DBClient, role string) *Service {
+	s := &Service{
+		dockerClient:   dc,
+		databaseClient: dbClient,
+		builds:         newBuildTracker(),
+		runs:           map[string]context.CancelFunc{},
 	}
+
+	s.pool = newContainerPool(s, poolInstance(role), poolSizesFromEnv())
+	s.pool.removeLeftovers()
+
+	// pools of a language are filled once its image is ready
+	s.StartImageBuilds()
 	return s
 }
 
@@ -50,59 +69,200 @@ func (ds *Service) ListRunningContainers() ([]types.Container, error) {
 	return containers, nil
 }
 
-func (ds *Service) RunLanguageContainer(lang Language, codeSrc string) (string, error) {
-	// Create a context with timeout to prevent hanging containers
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+// PoolStats returns the state of the warm container pool of every language.
+func (ds *Service) PoolStats() []PoolStats {
+	if ds.pool == nil {
+		return []PoolStats{}
+	}
+	return ds.pool.Stats()
+}
 
-	println("running", lang, codeSrc)
+// Cancel stops the run with the given ID, killing its container. It reports
+// whether such a run was in progress.
+func (ds *Service) Cancel(runID string) bool {
+	ds.runsMu.Lock()
+	cancel, ok := ds.runs[runID]
+	ds.runsMu.Unlock()
 
-	image, ok := LanguageToImageMap[lang]
-	if !ok {
-		return "", fmt.Errorf("unsupported language: %s", lang)
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// trackRun registers a cancellable context for the run with the given ID.
+// The returned func must be called once the run is over.
+func (ds *Service) trackRun(ctx context.Context, runID string) (context.Context, func(), error) {
+	ds.runsMu.Lock()
+	defer ds.runsMu.Unlock()
+
+	if _, ok := ds.runs[runID]; ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrRunExists, runID)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	ds.runs[runID] = cancel
+
+	return ctx, func() {
+		ds.runsMu.Lock()
+		delete(ds.runs, runID)
+		ds.runsMu.Unlock()
+		cancel()
+	}, nil
+}
+
+// RunLanguageContainer runs the program and returns its outcome. The run is
+// stopped when ctx is cancelled or it is cancelled through Cancel.
+func (ds *Service) RunLanguageContainer(ctx context.Context, rr RunRequest) (*ExecutionResult, error) {
+	if rr.ID == "" {
+		rr.ID = uuid.NewString()
+	}
+
+	stdout := newLimitedBuffer(MaxOutputBytes)
+	stderr := newLimitedBuffer(MaxOutputBytes)
+
+	result, err := ds.runContainer(ctx, rr, stdout, stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.Truncated = stdout.truncated || stderr.truncated
+	return result, nil
+}
+
+// RunLanguageContainerStream runs the program like RunLanguageContainer, but calls emit
+// with every stdout/stderr frame as soon as the container produces it. Once the
+// container exits, a final ExitEvent carrying the status code and duration is emitted.
+// The first event is a StartEvent with the ID that can be used to cancel the run.
+func (ds *Service) RunLanguageContainerStream(ctx context.Context, rr RunRequest, emit func(StreamEvent)) error {
+	if rr.ID == "" {
+		rr.ID = uuid.NewString()
+	}
+
+	emit(StreamEvent{Type: StartEvent, RunID: rr.ID})
+
+	stdout := &streamWriter{eventType: StdoutEvent, emit: emit}
+	stderr := &streamWriter{eventType: StderrEvent, emit: emit}
+
+	result, err := ds.runContainer(ctx, rr, stdout, stderr)
+	if err != nil {
+		return err
 	}
 
+	emit(StreamEvent{
+		Type:          ExitEvent,
+		RunID:         result.RunID,
+		StatusCode:    result.ExitCode,
+		Duration:      result.WallTime,
+		Phase:         result.Phase,
+		TimedOut:      result.TimedOut,
+		Canceled:      result.Canceled,
+		OOMKilled:     result.OOMKilled,
+		LimitExceeded: result.LimitExceeded,
+	})
+	return nil
+}
+
+// createRunContainer creates a container for the language with its sandbox policy
+// applied, ready to receive program files and be started.
+func (ds *Service) createRunContainer(ctx context.Context, spec LanguageSpec, labels map[string]string) (string, error) {
 	// Create container config with improved settings
 	config := &container.Config{
-		Image:        image,
+		Image:        spec.Image,
 		AttachStdin:  true,
 		AttachStdout: true,
 		AttachStderr: true,
 		OpenStdin:    true,
 		StdinOnce:    true,
+		Labels:       labels,
 	}
 
-	start := time.Now()
-	resp, err := ds.dockerClient.ContainerCreate(ctx, config, &container.HostConfig{
-		CapDrop: []string{"ALL"},
-	}, nil, nil, "")
+	resp, err := ds.dockerClient.ContainerCreate(ctx, config, spec.SandboxPolicy().hostConfig(), nil, nil, "")
 	if err != nil {
-		return "", fmt.Errorf("failed to create container: %w", err)
+		return "", err
 	}
+	return resp.ID, nil
+}
 
-	// Ensure container is removed even if we error out
-	defer func() {
-		timeout := 5 * time.Second
-		removeCtx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
+func (ds *Service) removeContainer(id string) {
+	timeout := 5 * time.Second
+	removeCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-		if err := ds.dockerClient.ContainerRemove(removeCtx, resp.ID, container.RemoveOptions{
-			Force: true,
-		}); err != nil {
-			log.Printf("failed to remove container %s: %v", resp.ID, err)
-		} else {
-			log.Printf("container %s removed", resp.ID)
-		}
-	}()
+	if err := ds.dockerClient.ContainerRemove(removeCtx, id, container.RemoveOptions{
+		Force:         true,
+		RemoveVolumes: true,
+	}); err != nil {
+		log.Printf("failed to remove container %s: %v", id, err)
+	} else {
+		log.Printf("container %s removed", id)
+	}
+}
+
+// runContainer takes a container for the request's language, copies the program
+// files into it, feeds it the request's stdin and copies the demultiplexed output
+// to stdout and stderr until the container exits, times out or is cancelled.
+// The returned result has everything but the captured output filled in.
+func (ds *Service) runContainer(ctx context.Context, rr RunRequest, stdout, stderr io.Writer) (*ExecutionResult, error) {
+	spec, ok := GetLanguage(rr.Language)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedLanguage, rr.Language)
+	}
+	if !ds.IsLanguageAvailable(rr.Language) {
+		return nil, fmt.Errorf("%w: %s", ErrLanguageUnavailable, rr.Language)
+	}
+	policy := spec.SandboxPolicy()
+
+	ctx, done, err := ds.trackRun(ctx, rr.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	// The run phase time limit cancels the run with errRunTimeLimit as its cause
+	ctx, cancelRun := context.WithCancelCause(ctx)
+	defer cancelRun(nil)
+
+	// Create a context with timeout to prevent hanging containers
+	ctx, cancel := context.WithTimeout(ctx, policy.Timeout)
+	defer cancel()
+
+	log.Printf("running %s program %s", rr.Language, rr.ID)
 
-	if err := ds.dockerClient.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		return "", fmt.Errorf("failed to start container: %w", err)
+	if err := rr.Validate(); err != nil {
+		return nil, err
+	}
+
+	archive, err := createRunArchive(rr, spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run archive: %w", err)
 	}
 
-	log.Printf("Container %s started in %v", resp.ID, time.Since(start))
+	start := time.Now()
 
-	// Attach to container with improved error handling
-	attachResp, err := ds.dockerClient.ContainerAttach(ctx, resp.ID, container.AttachOptions{
+	// Take a warm container from the pool and only create one when it is empty
+	var containerID string
+	if ds.pool != nil {
+		containerID = ds.pool.acquire(rr.Language)
+	}
+	if containerID == "" {
+		containerID, err = ds.createRunContainer(ctx, spec, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create container: %w", err)
+		}
+	}
+
+	// Ensure container is removed even if we error out, containers are never reused
+	defer ds.removeContainer(containerID)
+
+	if err := ds.dockerClient.CopyToContainer(ctx, containerID, programInputDir, archive, container.CopyToContainerOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to copy program files to container: %w", err)
+	}
+
+	// Attach before starting the container so no early output is lost
+	attachResp, err := ds.dockerClient.ContainerAttach(ctx, containerID, container.AttachOptions{
 		Stream: true,
 		Stdin:  true,
 		Stdout: true,
@@ -110,137 +270,186 @@ func (ds *Service) RunLanguageContainer(lang Language, codeSrc string) (string,
 	})
 
 	if err != nil {
-		return "", fmt.Errorf("failed to attach to container: %w", err)
+		return nil, fmt.Errorf("failed to attach to container: %w", err)
 	}
 	defer attachResp.Close()
 
+	if err := ds.dockerClient.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to start container: %w", err)
+	}
+
+	log.Printf("Container %s started in %v", containerID, time.Since(start))
+
 	// Create error channels for input/output operations
 	inputDone := make(chan error, 1)
 	outputDone := make(chan error, 1)
-	var outputBuf bytes.Buffer
+	// runStarted is only written by the output goroutine, read it after outputDone
+	var runStarted time.Time
+	phase := &phaseWriter{w: stderr, phase: RunPhase, onPhase: func(p Phase) {
+		if p != RunPhase || !runStarted.IsZero() {
+			return
+		}
+		runStarted = time.Now()
+		if rr.TimeLimitMs > 0 {
+			time.AfterFunc(time.Duration(rr.TimeLimitMs)*time.Millisecond, func() {
+				cancelRun(errRunTimeLimit)
+			})
+		}
+	}}
 
-	// Write code to container in a separate goroutine
+	// Write stdin to container in a separate goroutine
 	go func() {
 		defer func() {
 			_ = attachResp.CloseWrite()
 		}()
-		_, err := io.Copy(attachResp.Conn, strings.NewReader(codeSrc))
+		_, err := io.Copy(attachResp.Conn, strings.NewReader(rr.Stdin))
 		inputDone <- err
 	}()
 
 	// Read container output in separate goroutine
 	go func() {
-		_, err := stdcopy.StdCopy(&outputBuf, &outputBuf, attachResp.Reader)
+		_, err := stdcopy.StdCopy(stdout, phase, attachResp.Reader)
 		outputDone <- err
 	}()
 
+	result := &ExecutionResult{RunID: rr.ID}
+	// stopped is set when the run was cut short by a timeout or a cancellation
+	stopped := false
+
 	// Wait for container completion with timeout
-	statusCh, errCh := ds.dockerClient.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	statusCh, errCh := ds.dockerClient.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
 	select {
 	case err := <-errCh:
-		return "", fmt.Errorf("container wait error: %w", err)
+		if ctx.Err() == nil {
+			return nil, fmt.Errorf("container wait error: %w", err)
+		}
+		stopped = true
 	case status := <-statusCh:
-		// Handle input completion
+		// Programs don't have to read all of their stdin, so this is not fatal
 		if err := <-inputDone; err != nil {
-			return "", fmt.Errorf("error writing to container: %w", err)
+			log.Printf("failed to write stdin to container %s: %v", containerID, err)
 		}
 
 		// Handle output completion
 		if err := <-outputDone; err != nil {
-			return "", fmt.Errorf("error reading container output: %w", err)
+			return nil, fmt.Errorf("error reading container output: %w", err)
 		}
 
-		log.Printf("Container %s completed execution in %v", resp.ID, time.Since(start))
-
-		if status.StatusCode != 0 {
-			// Get container logs for error details
-			logOptions := container.LogsOptions{
-				ShowStdout: true,
-				ShowStderr: true,
-				Since:      start.Format(time.RFC3339),
-			}
-
-			logs, err := ds.dockerClient.ContainerLogs(ctx, resp.ID, logOptions)
-			if err != nil {
-				return "", fmt.Errorf("container failed with status %d and error getting logs: %w",
-					status.StatusCode, err)
-			}
-			defer func() {
-				_ = logs.Close()
-			}()
-
-			var errorOutput bytes.Buffer
-			if _, err := stdcopy.StdCopy(&errorOutput, &errorOutput, logs); err != nil {
-				return "", fmt.Errorf("container failed with status %d and error reading logs: %w",
-					status.StatusCode, err)
-			}
-
-			errorOutputBytes := errorOutput.Bytes()
-			if len(errorOutputBytes) > 10240 {
-				errorOutputBytes = errorOutputBytes[:10*1024]
-			}
-			return string(errorOutputBytes), fmt.Errorf("container failed with status %d",
-				status.StatusCode)
+		result.ExitCode = int(status.StatusCode)
+	case <-ctx.Done():
+		stopped = true
+	}
+
+	finished := time.Now()
+	result.WallTime = finished.Sub(start).Milliseconds()
+
+	inspectCtx, cancelInspect := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelInspect()
+
+	if stopped {
+		cause := context.Cause(ctx)
+		result.TimedOut = errors.Is(cause, context.DeadlineExceeded) || errors.Is(cause, errRunTimeLimit)
+		result.Canceled = !result.TimedOut
+		log.Printf("Container %s stopped after %v: %v", containerID, time.Since(start), ctx.Err())
+		if err := ds.dockerClient.ContainerKill(inspectCtx, containerID, "SIGKILL"); err != nil {
+			log.Printf("failed to kill container %s: %v", containerID, err)
 		}
+		// the output goroutine still owns the writers until the stream is closed
+		attachResp.Close()
+		<-outputDone
+	} else {
+		log.Printf("Container %s completed execution in %v", containerID, time.Since(start))
+	}
 
-	case <-ctx.Done():
-		return "", fmt.Errorf("container execution timed out after %v", time.Since(start))
+	if info, err := ds.dockerClient.ContainerInspect(inspectCtx, containerID); err == nil && info.State != nil {
+		result.OOMKilled = info.State.OOMKilled
+		if stopped {
+			result.ExitCode = info.State.ExitCode
+		}
 	}
 
-	output := outputBuf.String()
-	//log.Printf("Output:\n%s", output)
-	return output, nil
+	if !runStarted.IsZero() {
+		result.RunTime = finished.Sub(runStarted).Milliseconds()
+	}
+	result.Phase = phase.phase
+	result.LimitExceeded = limitExceeded(result)
+	return result, nil
 }
 
+// BuildLanguageImage rebuilds the image of language, even if it is up to date.
 func (ds *Service) BuildLanguageImage(language Language) error {
-	log.Println("building image", language)
-	dockerfile := LanguageToDockerFileMap[language]
+	spec, ok := GetLanguage(language)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedLanguage, language)
+	}
 
-	buildContext, err := createBuildContext(dockerfile, "run.sh", "run-cpp.sh", "run-rust.sh")
+	hash, err := buildContextHash(spec.Dockerfile, buildContextFiles...)
 	if err != nil {
 		return err
 	}
 
-	imgBuildResponse, err := ds.dockerClient.ImageBuild(context.Background(), buildContext, types.ImageBuildOptions{
-		Tags:       []string{LanguageToImageMap[language]},
-		Dockerfile: dockerfile,
-		Remove:     false,
-	})
-
-	if err != nil {
-		log.Println("error while building image", err)
+	if err := ds.buildImage(spec, hash); err != nil {
 		return err
 	}
 
-	defer func() {
-		_ = imgBuildResponse.Body.Close()
-	}()
+	ds.imageReady(spec.Image, true)
+	return nil
+}
 
-	body, err := io.ReadAll(imgBuildResponse.Body)
-	if err != nil {
-		log.Println("error reading build response body:", err)
-		return err
+// buildImage builds the image of spec labelled with the hash of its build
+// context. It returns ErrBuildInProgress when the image is already being built.
+// The languages using the image stay available if they were, the previous image
+// is only replaced once the build succeeded.
+func (ds *Service) buildImage(spec LanguageSpec, hash string) error {
+	dockerfile := spec.Dockerfile
+
+	started := time.Now()
+	if !ds.builds.start(spec.Image, hash, started) {
+		return fmt.Errorf("%w: %s", ErrBuildInProgress, spec.Image)
 	}
-	fmt.Printf(`
-----------------------------------------
-     BUILD OUTPUT FOR "%s":
-----------------------------------------
+	log.Println("building image", spec.Image)
 
-%s
-`, language, string(body))
+	err := func() error {
+		buildContext, err := createBuildContext(dockerfile, buildContextFiles...)
+		if err != nil {
+			return err
+		}
 
-	return err
-}
+		imgBuildResponse, err := ds.dockerClient.ImageBuild(context.Background(), buildContext, types.ImageBuildOptions{
+			Tags:       []string{spec.Image},
+			Dockerfile: dockerfile,
+			Remove:     false,
+			Labels:     map[string]string{buildHashLabel: hash},
+		})
 
-func (ds *Service) SetupClient() error {
-	// Build all language images
-	for _, language := range SupportedLanguages {
-		err := ds.BuildLanguageImage(language)
 		if err != nil {
+			log.Println("error while building image", err)
 			return err
 		}
+
+		defer func() {
+			_ = imgBuildResponse.Body.Close()
+		}()
+
+		return ds.builds.readBuildOutput(spec.Image, imgBuildResponse.Body)
+	}()
+
+	finished := time.Now()
+	ds.builds.update(spec.Image, func(s *BuildStatus) {
+		s.FinishedAt = &finished
+		if err != nil {
+			s.State = BuildFailed
+			s.Error = err.Error()
+		} else {
+			s.State = BuildReady
+			s.Available = true
+		}
+	})
+
+	if err == nil {
+		log.Printf("image %s built in %v", spec.Image, finished.Sub(started))
 	}
-	return nil
+	return err
 }
 
 func createBuildContext(dockerfile string, files ...string) (io.Reader, error) {
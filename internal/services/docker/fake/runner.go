@@ -0,0 +1,170 @@
+// Package fake provides an in-process docker.Runner that returns scripted
+// results, so code depending on a runner can be exercised without a docker daemon.
+package fake
+
+import (
+	"code-garden-server/internal/services/docker"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Response is a scripted outcome of a single run.
+type Response struct {
+	Result *docker.ExecutionResult
+	Err    error
+}
+
+// Runner returns the queued Responses in order, one per run. Once they are used
+// up every run succeeds and echoes its stdin back. Every request is recorded.
+type Runner struct {
+	mu         sync.Mutex
+	responses  []Response
+	requests   []docker.RunRequest
+	cancelled  []string
+	Containers []types.Container
+	// BuildErrors holds the error BuildLanguageImage returns for a language
+	BuildErrors map[docker.Language]error
+	Pool        []docker.PoolStats
+}
+
+func NewRunner(responses ...Response) *Runner {
+	return &Runner{responses: responses, BuildErrors: map[docker.Language]error{}}
+}
+
+// Enqueue adds scripted responses for upcoming runs.
+func (fr *Runner) Enqueue(responses ...Response) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.responses = append(fr.responses, responses...)
+}
+
+// Requests returns every run request received so far.
+func (fr *Runner) Requests() []docker.RunRequest {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return append([]docker.RunRequest{}, fr.requests...)
+}
+
+// Cancelled returns the run IDs passed to Cancel.
+func (fr *Runner) Cancelled() []string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return append([]string{}, fr.cancelled...)
+}
+
+func (fr *Runner) next(rr docker.RunRequest) (*docker.ExecutionResult, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	fr.requests = append(fr.requests, rr)
+
+	if _, ok := docker.GetLanguage(rr.Language); !ok {
+		return nil, fmt.Errorf("%w: %s", docker.ErrUnsupportedLanguage, rr.Language)
+	}
+	if fr.BuildErrors[rr.Language] != nil {
+		return nil, fmt.Errorf("%w: %s", docker.ErrLanguageUnavailable, rr.Language)
+	}
+	if err := rr.Validate(); err != nil {
+		return nil, err
+	}
+
+	if len(fr.responses) == 0 {
+		return &docker.ExecutionResult{RunID: rr.ID, Stdout: rr.Stdin, Phase: docker.RunPhase}, nil
+	}
+
+	res := fr.responses[0]
+	fr.responses = fr.responses[1:]
+	if res.Err != nil {
+		return nil, res.Err
+	}
+
+	result := *res.Result
+	result.RunID = rr.ID
+	return &result, nil
+}
+
+func (fr *Runner) RunLanguageContainer(_ context.Context, rr docker.RunRequest) (*docker.ExecutionResult, error) {
+	return fr.next(rr)
+}
+
+// RunLanguageContainerStream emits the scripted stdout and stderr line by line
+// before the exit event.
+func (fr *Runner) RunLanguageContainerStream(_ context.Context, rr docker.RunRequest, emit func(docker.StreamEvent)) error {
+	emit(docker.StreamEvent{Type: docker.StartEvent, RunID: rr.ID})
+
+	result, err := fr.next(rr)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.SplitAfter(result.Stdout, "\n") {
+		if line != "" {
+			emit(docker.StreamEvent{Type: docker.StdoutEvent, Data: line})
+		}
+	}
+	for _, line := range strings.SplitAfter(result.Stderr, "\n") {
+		if line != "" {
+			emit(docker.StreamEvent{Type: docker.StderrEvent, Data: line})
+		}
+	}
+
+	emit(docker.StreamEvent{
+		Type:          docker.ExitEvent,
+		RunID:         result.RunID,
+		StatusCode:    result.ExitCode,
+		Duration:      result.WallTime,
+		Phase:         result.Phase,
+		TimedOut:      result.TimedOut,
+		Canceled:      result.Canceled,
+		OOMKilled:     result.OOMKilled,
+		LimitExceeded: result.LimitExceeded,
+	})
+	return nil
+}
+
+// Cancel records the run ID. Fake runs finish immediately, so there is never
+// anything in progress to cancel.
+func (fr *Runner) Cancel(runID string) bool {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.cancelled = append(fr.cancelled, runID)
+	return false
+}
+
+func (fr *Runner) ListRunningContainers() ([]types.Container, error) {
+	return fr.Containers, nil
+}
+
+func (fr *Runner) BuildLanguageImage(language docker.Language) error {
+	return fr.BuildErrors[language]
+}
+
+func (fr *Runner) BuildStatuses() []docker.BuildStatus {
+	statuses := []docker.BuildStatus{}
+	for _, spec := range docker.Languages() {
+		status := docker.BuildStatus{Language: spec.Name, Image: spec.Image, State: docker.BuildReady, Available: true}
+		if err := fr.BuildErrors[spec.Name]; err != nil {
+			status.Available = false
+			status.State = docker.BuildFailed
+			status.Error = err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// IsLanguageAvailable reports every language as available unless it has a build error.
+func (fr *Runner) IsLanguageAvailable(lang docker.Language) bool {
+	_, ok := docker.GetLanguage(lang)
+	return ok && fr.BuildErrors[lang] == nil
+}
+
+func (fr *Runner) PoolStats() []docker.PoolStats {
+	return fr.Pool
+}
+
+var _ docker.Runner = (*Runner)(nil)
@@ -0,0 +1,262 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// buildHashLabel is the image label holding the hash of the build context the
+// image was built from.
+const buildHashLabel = "code-garden.build-hash"
+
+// buildContextFiles are the files besides the Dockerfile sent with every image build.
+var buildContextFiles = []string{"run.sh"}
+
+var ErrLanguageUnavailable = errors.New("language is not available yet")
+
+var ErrBuildInProgress = errors.New("the image is already being built")
+
+type BuildState string
+
+const (
+	BuildPending  BuildState = "pending"
+	BuildBuilding BuildState = "building"
+	BuildReady    BuildState = "ready"
+	BuildFailed   BuildState = "failed"
+)
+
+// BuildStatus is the state of the image of a language. Languages sharing an
+// image share its build.
+type BuildStatus struct {
+	Language Language   `json:"language"`
+	Image    string     `json:"image"`
+	State    BuildState `json:"state"`
+	// Available is set while a working image is tagged, which stays the case
+	// while it is rebuilt or when a rebuild failed
+	Available bool   `json:"available"`
+	Hash      string `json:"hash"`
+	// Cached is set when the existing image already matched the build context
+	Cached     bool       `json:"cached"`
+	Progress   string     `json:"progress,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// buildTracker keeps the build status of every language.
+type buildTracker struct {
+	mu       sync.RWMutex
+	statuses map[Language]*BuildStatus
+}
+
+func newBuildTracker() *buildTracker {
+	bt := &buildTracker{statuses: map[Language]*BuildStatus{}}
+	for _, spec := range Languages() {
+		bt.statuses[spec.Name] = &BuildStatus{Language: spec.Name, Image: spec.Image, State: BuildPending}
+	}
+	return bt
+}
+
+// update applies fn to the status of every language built from image.
+func (bt *buildTracker) update(image string, fn func(*BuildStatus)) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	for _, status := range bt.statuses {
+		if status.Image == image {
+			fn(status)
+		}
+	}
+}
+
+// start marks image as building unless it already is, in which case it
+// reports false and the caller must not build it too.
+func (bt *buildTracker) start(image, hash string, started time.Time) bool {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	for _, status := range bt.statuses {
+		if status.Image == image && status.State == BuildBuilding {
+			return false
+		}
+	}
+
+	for _, status := range bt.statuses {
+		if status.Image == image {
+			status.State = BuildBuilding
+			status.Hash = hash
+			status.Cached = false
+			status.Progress = ""
+			status.Error = ""
+			status.StartedAt = &started
+			status.FinishedAt = nil
+		}
+	}
+	return true
+}
+
+func (bt *buildTracker) ready(lang Language) bool {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	status, ok := bt.statuses[lang]
+	return ok && status.Available
+}
+
+func (bt *buildTracker) list() []BuildStatus {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+
+	statuses := make([]BuildStatus, 0, len(bt.statuses))
+	for _, spec := range Languages() {
+		if status, ok := bt.statuses[spec.Name]; ok {
+			statuses = append(statuses, *status)
+		}
+	}
+	return statuses
+}
+
+// buildContextHash hashes the names and contents of the files making up a
+// build context, so an image only has to be rebuilt when one of them changes.
+func buildContextHash(dockerfile string, files ...string) (string, error) {
+	h := sha256.New()
+	for _, file := range append([]string{dockerfile}, files...) {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		_, _ = fmt.Fprintf(h, "%s\x00%d\x00", file, len(content))
+		h.Write(content)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readBuildOutput follows the JSON stream of an image build, recording the
+// latest step as the progress of the build. The build only fails through the
+// stream, so its error is returned here.
+func (bt *buildTracker) readBuildOutput(image string, body io.Reader) error {
+	decoder := json.NewDecoder(body)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if msg.ErrorMessage != "" {
+			return errors.New(msg.ErrorMessage)
+		}
+
+		line := strings.TrimSpace(msg.Stream)
+		if line == "" {
+			continue
+		}
+		log.Printf("[build %s] %s", image, line)
+
+		if strings.HasPrefix(line, "Step ") || strings.HasPrefix(line, "#") {
+			bt.update(image, func(s *BuildStatus) {
+				s.Progress = line
+			})
+		}
+	}
+}
+
+// ensureImage builds the image of spec unless an image built from the same
+// build context already exists. It reports whether a new image was built.
+func (ds *Service) ensureImage(spec LanguageSpec) (bool, error) {
+	hash, err := buildContextHash(spec.Dockerfile, buildContextFiles...)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	info, _, err := ds.dockerClient.ImageInspectWithRaw(ctx, spec.Image)
+	cancel()
+
+	if err == nil && info.Config != nil && info.Config.Labels[buildHashLabel] == hash {
+		now := time.Now()
+		ds.builds.update(spec.Image, func(s *BuildStatus) {
+			s.State = BuildReady
+			s.Available = true
+			s.Hash = hash
+			s.Cached = true
+			s.FinishedAt = &now
+		})
+		log.Printf("image %s is up to date", spec.Image)
+		return false, nil
+	}
+
+	// an outdated image still runs code until the new one is built
+	if err == nil {
+		ds.builds.update(spec.Image, func(s *BuildStatus) {
+			s.Available = true
+		})
+	}
+
+	return true, ds.buildImage(spec, hash)
+}
+
+// StartImageBuilds makes sure the image of every language is up to date in the
+// background, building different images concurrently. Languages only become
+// available once their image is ready, and a failed build only takes down the
+// languages using that image.
+func (ds *Service) StartImageBuilds() {
+	images := map[string]LanguageSpec{}
+	for _, spec := range Languages() {
+		if _, ok := images[spec.Image]; !ok {
+			images[spec.Image] = spec
+		}
+	}
+
+	for _, spec := range images {
+		go func(spec LanguageSpec) {
+			rebuilt, err := ds.ensureImage(spec)
+			if err != nil {
+				log.Printf("failed to build image %s: %v", spec.Image, err)
+				return
+			}
+			ds.imageReady(spec.Image, rebuilt)
+		}(spec)
+	}
+}
+
+// imageReady refills the container pools of the languages using image. Idle
+// containers created from an older image are thrown away first.
+func (ds *Service) imageReady(image string, rebuilt bool) {
+	if ds.pool == nil {
+		return
+	}
+	for _, spec := range Languages() {
+		if spec.Image != image {
+			continue
+		}
+		if rebuilt {
+			ds.pool.discard(spec.Name)
+		}
+		go ds.pool.refill(spec.Name)
+	}
+}
+
+// IsLanguageAvailable reports whether code in lang can be run right now.
+func (ds *Service) IsLanguageAvailable(lang Language) bool {
+	return ds.builds.ready(lang)
+}
+
+// BuildStatuses returns the image build status of every language.
+func (ds *Service) BuildStatuses() []BuildStatus {
+	return ds.builds.list()
+}
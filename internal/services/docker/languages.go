@@ -0,0 +1,155 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type Language string
+
+// DefaultLanguagesFile is the language registry loaded when LANGUAGES_FILE is not set.
+const DefaultLanguagesFile = "languages.json"
+
+// LanguageLimits overrides the DefaultSandboxPolicy for a language. Zero values
+// keep the default.
+type LanguageLimits struct {
+	MemoryMb     int64   `json:"memoryMb,omitempty"`
+	SwapMb       int64   `json:"swapMb,omitempty"`
+	Cpus         float64 `json:"cpus,omitempty"`
+	Pids         int64   `json:"pids,omitempty"`
+	WorkDirMb    int64   `json:"workDirMb,omitempty"`
+	TmpMb        int64   `json:"tmpMb,omitempty"`
+	MaxOpenFiles int64   `json:"maxOpenFiles,omitempty"`
+	MaxFileMb    int64   `json:"maxFileMb,omitempty"`
+}
+
+// LanguageSpec describes how code in a language is built and run. Source code is
+// written to SourceFile, compiled with CompileCommand when there is one and run
+// with RunCommand inside the language's image.
+type LanguageSpec struct {
+	Name           Language       `json:"name"`
+	DisplayName    string         `json:"displayName"`
+	Image          string         `json:"image"`
+	Dockerfile     string         `json:"dockerfile"`
+	Extension      string         `json:"extension"`
+	SourceFile     string         `json:"sourceFile"`
+	CompileCommand string         `json:"compileCommand,omitempty"`
+	RunCommand     string         `json:"runCommand"`
+	TimeoutSeconds int            `json:"timeoutSeconds,omitempty"`
+	Limits         LanguageLimits `json:"limits"`
+	// PoolSize is the default number of warm containers, see poolSizesFromEnv
+	PoolSize *int `json:"poolSize,omitempty"`
+	// Project is set for languages that can run multi-file projects
+	Project *ProjectSpec `json:"project,omitempty"`
+}
+
+// SandboxPolicy returns the DefaultSandboxPolicy with the language's limits applied.
+func (ls LanguageSpec) SandboxPolicy() SandboxPolicy {
+	policy := DefaultSandboxPolicy
+	if ls.TimeoutSeconds > 0 {
+		policy.Timeout = time.Duration(ls.TimeoutSeconds) * time.Second
+	}
+
+	l := ls.Limits
+	if l.MemoryMb > 0 {
+		policy.MemoryBytes = l.MemoryMb * MiB
+	}
+	if l.SwapMb > 0 {
+		policy.SwapBytes = l.SwapMb * MiB
+	}
+	if l.Cpus > 0 {
+		policy.NanoCPUs = int64(l.Cpus * 1e9)
+	}
+	if l.Pids > 0 {
+		policy.PidsLimit = l.Pids
+	}
+	if l.WorkDirMb > 0 {
+		policy.WorkDirBytes = l.WorkDirMb * MiB
+	}
+	if l.TmpMb > 0 {
+		policy.TmpBytes = l.TmpMb * MiB
+	}
+	if l.MaxOpenFiles > 0 {
+		policy.MaxOpenFiles = l.MaxOpenFiles
+	}
+	if l.MaxFileMb > 0 {
+		policy.MaxFileBytes = l.MaxFileMb * MiB
+	}
+	return policy
+}
+
+func (ls LanguageSpec) validate() error {
+	switch {
+	case ls.Name == "":
+		return fmt.Errorf("language without a name")
+	case ls.Image == "" || ls.Dockerfile == "":
+		return fmt.Errorf("language %s: image and dockerfile are required", ls.Name)
+	case ls.SourceFile == "" || ls.RunCommand == "":
+		return fmt.Errorf("language %s: sourceFile and runCommand are required", ls.Name)
+	case ls.Project != nil && (ls.Project.Entrypoint == "" || ls.Project.RunCommand == ""):
+		return fmt.Errorf("language %s: project entrypoint and runCommand are required", ls.Name)
+	}
+	return nil
+}
+
+var (
+	languagesMu sync.RWMutex
+	languages   []LanguageSpec
+	byName      = map[Language]LanguageSpec{}
+)
+
+// LoadLanguages reads the language registry from a JSON file and makes it the
+// set of supported languages.
+func LoadLanguages(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read language registry: %w", err)
+	}
+
+	var registry struct {
+		Languages []LanguageSpec `json:"languages"`
+	}
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return fmt.Errorf("failed to parse language registry %s: %w", path, err)
+	}
+
+	return SetLanguages(registry.Languages)
+}
+
+// SetLanguages replaces the set of supported languages.
+func SetLanguages(specs []LanguageSpec) error {
+	names := map[Language]LanguageSpec{}
+	for _, spec := range specs {
+		if err := spec.validate(); err != nil {
+			return err
+		}
+		if _, ok := names[spec.Name]; ok {
+			return fmt.Errorf("language %s is defined twice", spec.Name)
+		}
+		names[spec.Name] = spec
+	}
+
+	languagesMu.Lock()
+	defer languagesMu.Unlock()
+	languages = specs
+	byName = names
+	return nil
+}
+
+// GetLanguage returns the spec of a supported language.
+func GetLanguage(lang Language) (LanguageSpec, bool) {
+	languagesMu.RLock()
+	defer languagesMu.RUnlock()
+	spec, ok := byName[lang]
+	return spec, ok
+}
+
+// Languages returns every supported language in registry order.
+func Languages() []LanguageSpec {
+	languagesMu.RLock()
+	defer languagesMu.RUnlock()
+	return append([]LanguageSpec{}, languages...)
+}
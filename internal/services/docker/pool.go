@@ -0,0 +1,234 @@
+package docker
+
+import (
+	"code-garden-server/config"
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+const (
+	// DefaultPoolSize is the number of idle containers kept per language when
+	// CONTAINER_POOL_SIZE is not set.
+	DefaultPoolSize = 2
+
+	poolLabel         = "code-garden.pool"
+	poolLanguageLabel = "code-garden.language"
+	// poolInstanceLabel holds the instance of the process that created a pool container
+	poolInstanceLabel = "code-garden.instance"
+)
+
+// PoolStats describes the warm containers of a single language.
+type PoolStats struct {
+	Language Language `json:"language"`
+	Size     int      `json:"size"`
+	Idle     int      `json:"idle"`
+	Hits     int      `json:"hits"`
+	Misses   int      `json:"misses"`
+	Errors   int      `json:"errors"`
+}
+
+// containerPool keeps created but not yet started containers for every language,
+// so a run only has to copy its files in and start the container. A container is
+// handed out once and removed after its run; it is never reused.
+type containerPool struct {
+	ds *Service
+	// instance tells the containers of this process apart from those of other
+	// processes sharing the docker daemon
+	instance string
+	mu       sync.Mutex
+	sizes    map[Language]int
+	idle     map[Language][]string
+	filling  map[Language]bool
+	stats    map[Language]*PoolStats
+}
+
+func newContainerPool(ds *Service, instance string, sizes map[Language]int) *containerPool {
+	p := &containerPool{
+		ds:       ds,
+		instance: instance,
+		sizes:    sizes,
+		idle:     map[Language][]string{},
+		filling:  map[Language]bool{},
+		stats:    map[Language]*PoolStats{},
+	}
+	for lang, size := range sizes {
+		p.stats[lang] = &PoolStats{Language: lang, Size: size}
+	}
+	return p
+}
+
+// poolSizesFromEnv reads the pool size of every language from CONTAINER_POOL_SIZE,
+// or the poolSize of the language in the registry. Both can be overridden per
+// language, e.g. CONTAINER_POOL_SIZE_PYTHON=5.
+func poolSizesFromEnv() map[Language]int {
+	defaultSize := DefaultPoolSize
+	if v, err := strconv.Atoi(config.GetEnv("CONTAINER_POOL_SIZE")); err == nil {
+		defaultSize = v
+	}
+
+	sizes := map[Language]int{}
+	for _, spec := range Languages() {
+		sizes[spec.Name] = defaultSize
+		if spec.PoolSize != nil {
+			sizes[spec.Name] = *spec.PoolSize
+		}
+		key := "CONTAINER_POOL_SIZE_" + strings.ToUpper(string(spec.Name))
+		if v, err := strconv.Atoi(config.GetEnv(key)); err == nil {
+			sizes[spec.Name] = v
+		}
+	}
+	return sizes
+}
+
+// poolInstance returns the instance the pool containers of a process are
+// labelled with: CONTAINER_POOL_INSTANCE, or the host name and the role of the
+// process. It stays the same when the process restarts, so it can clean up after
+// itself, and has to differ between processes sharing a docker daemon, e.g. by
+// setting CONTAINER_POOL_INSTANCE when several workers run on one host.
+func poolInstance(role string) string {
+	if instance := config.GetEnv("CONTAINER_POOL_INSTANCE"); instance != "" {
+		return instance
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return hostname + "/" + role
+}
+
+// removeLeftovers removes the pool containers a previous run of this instance
+// left behind. Containers of other instances, which may be running code, are
+// left alone.
+func (p *containerPool) removeLeftovers() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	leftovers, err := p.ds.dockerClient.ContainerList(ctx, container.ListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", poolLabel),
+			filters.Arg("label", poolInstanceLabel+"="+p.instance),
+		),
+	})
+	if err != nil {
+		log.Printf("failed to list leftover pool containers: %v", err)
+	}
+	for _, c := range leftovers {
+		p.ds.removeContainer(c.ID)
+	}
+}
+
+// acquire returns an idle container for lang, or "" when the pool is empty.
+func (p *containerPool) acquire(lang Language) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats, ok := p.stats[lang]
+	if !ok {
+		return ""
+	}
+
+	idle := p.idle[lang]
+	if len(idle) == 0 {
+		stats.Misses++
+		go p.refill(lang)
+		return ""
+	}
+
+	id := idle[0]
+	p.idle[lang] = idle[1:]
+	stats.Hits++
+	go p.refill(lang)
+	return id
+}
+
+// refill creates containers for lang until the pool is back at its size.
+// Only one refill per language runs at a time.
+func (p *containerPool) refill(lang Language) {
+	if !p.ds.IsLanguageAvailable(lang) {
+		return
+	}
+
+	p.mu.Lock()
+	if p.filling[lang] {
+		p.mu.Unlock()
+		return
+	}
+	p.filling[lang] = true
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.filling[lang] = false
+		p.mu.Unlock()
+	}()
+
+	for {
+		p.mu.Lock()
+		missing := p.sizes[lang] - len(p.idle[lang])
+		p.mu.Unlock()
+
+		if missing <= 0 {
+			return
+		}
+
+		spec, ok := GetLanguage(lang)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		id, err := p.ds.createRunContainer(ctx, spec, map[string]string{
+			poolLabel:         "true",
+			poolLanguageLabel: string(lang),
+			poolInstanceLabel: p.instance,
+		})
+		cancel()
+
+		p.mu.Lock()
+		if err != nil {
+			p.stats[lang].Errors++
+			p.mu.Unlock()
+			log.Printf("failed to create pool container for %s: %v", lang, err)
+			return
+		}
+		p.idle[lang] = append(p.idle[lang], id)
+		p.mu.Unlock()
+	}
+}
+
+func (p *containerPool) Stats() []PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]PoolStats, 0, len(p.stats))
+	for _, spec := range Languages() {
+		s, ok := p.stats[spec.Name]
+		if !ok {
+			continue
+		}
+		s.Idle = len(p.idle[spec.Name])
+		stats = append(stats, *s)
+	}
+	return stats
+}
+
+// discard removes the idle containers of lang, e.g. after its image was rebuilt.
+func (p *containerPool) discard(lang Language) {
+	p.mu.Lock()
+	idle := p.idle[lang]
+	p.idle[lang] = nil
+	p.mu.Unlock()
+
+	for _, id := range idle {
+		p.ds.removeContainer(id)
+	}
+}
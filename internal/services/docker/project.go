@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	MaxProjectFiles = 100
+	MaxProjectBytes = 1024 * 1024
+	MaxPathLength   = 255
+)
+
+// ProjectSpec describes how multi-file projects in a language are built and run.
+// The files are copied into the work directory and the commands run from there
+// with ENTRYPOINT set to the path of the entrypoint file.
+type ProjectSpec struct {
+	// Entrypoint is used when a project doesn't name one
+	Entrypoint     string `json:"entrypoint"`
+	CompileCommand string `json:"compileCommand,omitempty"`
+	RunCommand     string `json:"runCommand"`
+	// DefaultFiles are added to projects that don't have them, e.g. a go.mod
+	DefaultFiles map[string]string `json:"defaultFiles,omitempty"`
+}
+
+// ValidateProjectFiles checks that files can be written into a container as a
+// tree: every path is relative, clean and not nested under another file, and
+// the entrypoint is one of the files.
+func ValidateProjectFiles(files map[string]string, entrypoint string) error {
+	if len(files) > MaxProjectFiles {
+		return fmt.Errorf("%w: more than %d files", ErrInvalidRunRequest, MaxProjectFiles)
+	}
+
+	size := 0
+	for p, content := range files {
+		if err := validateProjectPath(p); err != nil {
+			return err
+		}
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if _, ok := files[dir]; ok {
+				return fmt.Errorf("%w: %q is both a file and a directory", ErrInvalidRunRequest, dir)
+			}
+		}
+		size += len(content)
+	}
+	if size > MaxProjectBytes {
+		return fmt.Errorf("%w: files are larger than %d bytes", ErrInvalidRunRequest, MaxProjectBytes)
+	}
+
+	if _, ok := files[entrypoint]; entrypoint != "" && !ok {
+		return fmt.Errorf("%w: entrypoint %q is not one of the files", ErrInvalidRunRequest, entrypoint)
+	}
+	return nil
+}
+
+func validateProjectPath(p string) error {
+	switch {
+	case p == "" || len(p) > MaxPathLength:
+		return fmt.Errorf("%w: file paths must be 1 to %d characters long", ErrInvalidRunRequest, MaxPathLength)
+	case strings.ContainsAny(p, "\\\x00"):
+		return fmt.Errorf("%w: invalid file path %q", ErrInvalidRunRequest, p)
+	case path.IsAbs(p) || path.Clean(p) != p || p == ".." || strings.HasPrefix(p, "../"):
+		return fmt.Errorf("%w: file path %q must be relative and clean", ErrInvalidRunRequest, p)
+	case p == path.Base(programInputDir) || strings.HasPrefix(p, path.Base(programInputDir)+"/"):
+		return fmt.Errorf("%w: file path %q is reserved", ErrInvalidRunRequest, p)
+	}
+	return nil
+}
+
+// projectFiles returns the files of the request with the language's default
+// files added, sorted by path.
+func projectFiles(rr RunRequest, project *ProjectSpec) ([]string, map[string]string) {
+	files := make(map[string]string, len(rr.Files)+len(project.DefaultFiles))
+	for p, content := range project.DefaultFiles {
+		files[p] = content
+	}
+	for p, content := range rr.Files {
+		files[p] = content
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths, files
+}
@@ -0,0 +1,123 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+// MaxOutputBytes is the maximum number of bytes kept for each of stdout and stderr.
+const MaxOutputBytes = 10 * 1024
+
+var ErrUnsupportedLanguage = errors.New("unsupported language")
+
+var ErrRunExists = errors.New("a run with this id is already in progress")
+
+// errRunTimeLimit is the cancellation cause of runs that exceed RunRequest.TimeLimitMs.
+var errRunTimeLimit = errors.New("run time limit exceeded")
+
+type Phase string
+
+const (
+	CompilePhase Phase = "compile"
+	RunPhase     Phase = "run"
+)
+
+// ExecutionResult describes how a program run went. A non-zero ExitCode, a
+// timeout or an OOM kill are all results of the user's program and not errors
+// of the service; those are returned separately.
+type ExecutionResult struct {
+	RunID    string `json:"runId"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+	WallTime int64  `json:"wallTime"` // milliseconds
+	// RunTime is how long the run phase took, without compiling, in milliseconds
+	RunTime   int64 `json:"runTime"`
+	Phase     Phase `json:"phase"`
+	TimedOut  bool  `json:"timedOut"`
+	Canceled  bool  `json:"canceled"`
+	OOMKilled bool  `json:"oomKilled"`
+	Truncated bool  `json:"truncated"`
+	// LimitExceeded names the sandbox limit that stopped the program, e.g. "memory"
+	LimitExceeded string `json:"limitExceeded,omitempty"`
+}
+
+// limitedBuffer keeps at most max bytes and silently drops the rest, so the
+// container output can still be drained completely.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func newLimitedBuffer(max int) *limitedBuffer {
+	return &limitedBuffer{max: max}
+}
+
+func (lb *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := lb.max - lb.buf.Len()
+	if len(p) > remaining {
+		lb.truncated = true
+		lb.buf.Write(p[:max(remaining, 0)])
+		return len(p), nil
+	}
+	return lb.buf.Write(p)
+}
+
+func (lb *limitedBuffer) String() string {
+	return lb.buf.String()
+}
+
+// phaseMarkerPrefix is written to stderr by run.sh when it switches between the
+// compile and the run phase, e.g. "::code-garden-phase::compile".
+const phaseMarkerPrefix = "::code-garden-phase::"
+
+// phaseWriter strips phase markers out of the stderr stream and remembers the
+// last phase the container reported. onPhase, when set, is called with every
+// phase as it is reported.
+type phaseWriter struct {
+	w       io.Writer
+	phase   Phase
+	onPhase func(Phase)
+}
+
+func (pw *phaseWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for {
+		i := bytes.Index(p, []byte(phaseMarkerPrefix))
+		if i < 0 {
+			break
+		}
+
+		end := bytes.IndexByte(p[i:], '\n')
+		if end < 0 {
+			end = len(p) - i
+		} else {
+			end++
+		}
+
+		pw.phase = Phase(strings.TrimSpace(string(p[i+len(phaseMarkerPrefix) : i+end])))
+		if pw.onPhase != nil {
+			pw.onPhase(pw.phase)
+		}
+		if err := pw.forward(p[:i]); err != nil {
+			return 0, err
+		}
+		p = p[i+end:]
+	}
+
+	if err := pw.forward(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (pw *phaseWriter) forward(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	_, err := pw.w.Write(p)
+	return err
+}
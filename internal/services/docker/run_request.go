@@ -0,0 +1,179 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	MaxStdinBytes = 1024 * 1024
+	MaxArgs       = 64
+	MaxEnvVars    = 64
+)
+
+// containerWorkDir is the home directory of the unprivileged user in every language image.
+const containerWorkDir = "/home/myuser"
+
+// programInputDir is where run.sh expects the program files. It has to be a volume
+// because files can't be copied onto the read-only root filesystem of a run container.
+const programInputDir = containerWorkDir + "/.code-garden"
+
+var ErrInvalidRunRequest = errors.New("invalid run request")
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var runIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// RunRequest is everything needed to run a program. Stdin is attached to the
+// program's standard input; the source, Args and Env are copied into the
+// container as files before it starts so they never mix with Stdin.
+//
+// A request with Files runs a multi-file project instead of Code, see ProjectSpec.
+type RunRequest struct {
+	// ID identifies the run so it can be cancelled while it is in progress
+	ID       string            `json:"runId"`
+	Language Language          `json:"language"`
+	Code     string            `json:"code"`
+	Stdin    string            `json:"stdin"`
+	Args     []string          `json:"args"`
+	Env      map[string]string `json:"env"`
+	// Files maps the paths of a project's files to their content
+	Files      map[string]string `json:"files,omitempty"`
+	Entrypoint string            `json:"entrypoint,omitempty"`
+	// TimeLimitMs caps the run phase in milliseconds, compiling doesn't count
+	// towards it. The language's timeout still applies to the whole run.
+	TimeLimitMs int64 `json:"timeLimitMs,omitempty"`
+}
+
+func (rr RunRequest) Validate() error {
+	if rr.ID != "" && !runIdPattern.MatchString(rr.ID) {
+		return fmt.Errorf("%w: invalid run id %q", ErrInvalidRunRequest, rr.ID)
+	}
+	if rr.TimeLimitMs < 0 {
+		return fmt.Errorf("%w: negative time limit", ErrInvalidRunRequest)
+	}
+	if len(rr.Stdin) > MaxStdinBytes {
+		return fmt.Errorf("%w: stdin is larger than %d bytes", ErrInvalidRunRequest, MaxStdinBytes)
+	}
+	if len(rr.Args) > MaxArgs {
+		return fmt.Errorf("%w: more than %d args", ErrInvalidRunRequest, MaxArgs)
+	}
+	if len(rr.Env) > MaxEnvVars {
+		return fmt.Errorf("%w: more than %d env variables", ErrInvalidRunRequest, MaxEnvVars)
+	}
+	for key := range rr.Env {
+		if !envKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: invalid env variable name %q", ErrInvalidRunRequest, key)
+		}
+	}
+	if rr.IsProject() {
+		return ValidateProjectFiles(rr.Files, rr.Entrypoint)
+	}
+	return nil
+}
+
+// IsProject reports whether the request runs a multi-file project.
+func (rr RunRequest) IsProject() bool {
+	return len(rr.Files) > 0
+}
+
+// createRunArchive builds the tar archive copied into programInputDir. It holds
+// the source code, or the project's files under project/, along with shell
+// snippets that run.sh sources: the manifest with the language's commands, and
+// the program's arguments and environment.
+func createRunArchive(rr RunRequest, spec LanguageSpec) (io.Reader, error) {
+	if rr.IsProject() && spec.Project == nil {
+		return nil, fmt.Errorf("%w: %s doesn't support multi-file projects", ErrInvalidRunRequest, spec.Name)
+	}
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	var args strings.Builder
+	args.WriteString("set --")
+	for _, arg := range rr.Args {
+		args.WriteString(" " + shellQuote(arg))
+	}
+	args.WriteString("\n")
+
+	keys := make([]string, 0, len(rr.Env))
+	for key := range rr.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var manifest strings.Builder
+	if rr.IsProject() {
+		entrypoint := rr.Entrypoint
+		if entrypoint == "" {
+			entrypoint = spec.Project.Entrypoint
+		}
+		manifest.WriteString("PROJECT=1\n")
+		manifest.WriteString(fmt.Sprintf("export ENTRYPOINT=%s\n", shellQuote(entrypoint)))
+		manifest.WriteString(fmt.Sprintf("COMPILE_CMD=%s\n", shellQuote(spec.Project.CompileCommand)))
+		manifest.WriteString(fmt.Sprintf("RUN_CMD=%s\n", shellQuote(spec.Project.RunCommand)))
+	} else {
+		manifest.WriteString(fmt.Sprintf("FILE=%s\n", shellQuote(spec.SourceFile)))
+		manifest.WriteString(fmt.Sprintf("COMPILE_CMD=%s\n", shellQuote(spec.CompileCommand)))
+		manifest.WriteString(fmt.Sprintf("RUN_CMD=%s\n", shellQuote(spec.RunCommand)))
+	}
+
+	var env strings.Builder
+	for _, key := range keys {
+		env.WriteString(fmt.Sprintf("export %s=%s\n", key, shellQuote(rr.Env[key])))
+	}
+
+	files := []archiveFile{
+		{"manifest", manifest.String()},
+		{"args", args.String()},
+		{"env", env.String()},
+	}
+	if rr.IsProject() {
+		paths, contents := projectFiles(rr, spec.Project)
+		for _, p := range paths {
+			files = append(files, archiveFile{"project/" + p, contents[p]})
+		}
+	} else {
+		files = append(files, archiveFile{"source", rr.Code})
+	}
+	for _, file := range files {
+		if err := writeTarFile(tw, file.name, []byte(file.content), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type archiveFile struct {
+	name    string
+	content string
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte, mode int64) error {
+	hdr := &tar.Header{
+		Name: name,
+		Mode: mode,
+		Size: int64(len(content)),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+// shellQuote wraps s in single quotes so that sh treats it as a single literal word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,23 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Runner runs user code and manages the environments it runs in. Service is
+// the docker backed implementation; the HTTP handlers and the job worker only
+// depend on this interface.
+type Runner interface {
+	RunLanguageContainer(ctx context.Context, rr RunRequest) (*ExecutionResult, error)
+	RunLanguageContainerStream(ctx context.Context, rr RunRequest, emit func(StreamEvent)) error
+	Cancel(runID string) bool
+	ListRunningContainers() ([]types.Container, error)
+	BuildLanguageImage(language Language) error
+	BuildStatuses() []BuildStatus
+	IsLanguageAvailable(lang Language) bool
+	PoolStats() []PoolStats
+}
+
+var _ Runner = (*Service)(nil)
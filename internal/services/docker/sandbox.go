@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+const (
+	KiB = 1024
+	MiB = 1024 * KiB
+)
+
+// SandboxPolicy is the set of limits applied to every container running user code.
+type SandboxPolicy struct {
+	Timeout     time.Duration
+	MemoryBytes int64
+	// SwapBytes is the swap allowed on top of MemoryBytes, 0 disables swap
+	SwapBytes     int64
+	NanoCPUs      int64
+	PidsLimit     int64
+	WorkDirBytes  int64
+	TmpBytes      int64
+	MaxOpenFiles  int64
+	MaxFileBytes  int64
+	NetworkAccess bool
+}
+
+var DefaultSandboxPolicy = SandboxPolicy{
+	Timeout:      15 * time.Second,
+	MemoryBytes:  256 * MiB,
+	NanoCPUs:     1_000_000_000,
+	PidsLimit:    64,
+	WorkDirBytes: 64 * MiB,
+	TmpBytes:     16 * MiB,
+	MaxOpenFiles: 256,
+	MaxFileBytes: 16 * MiB,
+}
+
+// hostConfig turns the policy into the docker host config for a run container.
+// The root filesystem is read-only: the program only gets a size limited tmpfs
+// as its home and /tmp, and its input files live on a volume that it can't write to.
+func (sp SandboxPolicy) hostConfig() *container.HostConfig {
+	pidsLimit := sp.PidsLimit
+	networkMode := container.NetworkMode("none")
+	if sp.NetworkAccess {
+		networkMode = "default"
+	}
+
+	return &container.HostConfig{
+		CapDrop:        []string{"ALL"},
+		SecurityOpt:    []string{"no-new-privileges"},
+		NetworkMode:    networkMode,
+		ReadonlyRootfs: true,
+		Tmpfs: map[string]string{
+			containerWorkDir: fmt.Sprintf("rw,exec,nosuid,nodev,size=%d,mode=1777", sp.WorkDirBytes),
+			"/tmp":           fmt.Sprintf("rw,noexec,nosuid,nodev,size=%d,mode=1777", sp.TmpBytes),
+		},
+		Mounts: []mount.Mount{
+			// an anonymous volume, removed along with the container
+			{Type: mount.TypeVolume, Target: programInputDir},
+		},
+		Resources: container.Resources{
+			Memory:     sp.MemoryBytes,
+			MemorySwap: sp.MemoryBytes + sp.SwapBytes,
+			NanoCPUs:   sp.NanoCPUs,
+			PidsLimit:  &pidsLimit,
+			Ulimits: []*container.Ulimit{
+				{Name: "nofile", Soft: sp.MaxOpenFiles, Hard: sp.MaxOpenFiles},
+				{Name: "fsize", Soft: sp.MaxFileBytes, Hard: sp.MaxFileBytes},
+				{Name: "core", Soft: 0, Hard: 0},
+			},
+		},
+	}
+}
+
+// exitCodeFileSizeExceeded is the exit code of a process killed by SIGXFSZ.
+const exitCodeFileSizeExceeded = 128 + 25
+
+// limitExceeded names the sandbox limit that ended the run, if any.
+func limitExceeded(result *ExecutionResult) string {
+	switch {
+	case result.OOMKilled:
+		return "memory"
+	case result.TimedOut:
+		return "time"
+	case result.ExitCode == exitCodeFileSizeExceeded:
+		return "file size"
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+package docker
+
+type StreamEventType string
+
+const (
+	StartEvent  StreamEventType = "start"
+	StdoutEvent StreamEventType = "stdout"
+	StderrEvent StreamEventType = "stderr"
+	ExitEvent   StreamEventType = "exit"
+)
+
+// StreamEvent is a single piece of live output from a running container.
+// The StartEvent carries the RunID and output events carry Data. The final
+// ExitEvent carries the status code and the duration of the run in milliseconds
+// along with the rest of the run outcome.
+type StreamEvent struct {
+	Type       StreamEventType `json:"type"`
+	RunID      string          `json:"runId,omitempty"`
+	Data       string          `json:"data,omitempty"`
+	StatusCode int             `json:"statusCode"`
+	Duration   int64           `json:"duration"`
+	Phase      Phase           `json:"phase,omitempty"`
+	TimedOut   bool            `json:"timedOut,omitempty"`
+	Canceled   bool            `json:"canceled,omitempty"`
+	OOMKilled  bool            `json:"oomKilled,omitempty"`
+	// LimitExceeded names the sandbox limit that stopped the program, e.g. "memory"
+	LimitExceeded string `json:"limitExceeded,omitempty"`
+}
+
+// streamWriter forwards every write it receives as a StreamEvent. stdcopy.StdCopy
+// writes each frame as soon as it is demultiplexed, so one write maps to one frame.
+type streamWriter struct {
+	eventType StreamEventType
+	emit      func(StreamEvent)
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.emit(StreamEvent{Type: sw.eventType, Data: string(p)})
+	return len(p), nil
+}
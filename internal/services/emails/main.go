@@ -1,13 +1,19 @@
 package emails
 
 import (
+	"bytes"
 	"code-garden-server/config"
 	"fmt"
+	htmltemplate "html/template"
 	"log"
+	"path/filepath"
+	"text/template"
 
 	"github.com/resend/resend-go/v2"
 )
 
+const templatesDir = "./internal/services/emails/templates"
+
 type Mail struct {
 	Emails  []string
 	Html    string
@@ -36,3 +42,33 @@ func SendMail(v Mail) error {
 	log.Println(sent.Id)
 	return nil
 }
+
+// SendTemplate renders the html and text templates called name with data and
+// mails them to every address in to.
+func SendTemplate(to []string, subject, name string, data interface{}) error {
+	var htmlBuf bytes.Buffer
+	var textBuf bytes.Buffer
+
+	tmplHtml, err := htmltemplate.ParseFiles(filepath.Join(templatesDir, name+".html"))
+	if err != nil {
+		return err
+	}
+	tmplText, err := template.ParseFiles(filepath.Join(templatesDir, name+".txt"))
+	if err != nil {
+		return err
+	}
+
+	if err := tmplHtml.Execute(&htmlBuf, data); err != nil {
+		return err
+	}
+	if err := tmplText.Execute(&textBuf, data); err != nil {
+		return err
+	}
+
+	return SendMail(Mail{
+		Emails:  to,
+		Html:    htmlBuf.String(),
+		Text:    textBuf.String(),
+		Subject: subject,
+	})
+}
@@ -0,0 +1,167 @@
+package jobs
+
+import (
+	"code-garden-server/internal/services/docker"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	r "code-garden-server/internal/database/redis"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// QueueKey is the redis list that pending job IDs are pushed to.
+const QueueKey = "JobQueue"
+
+// CancelChannel is the redis channel workers listen on for IDs of jobs to cancel.
+const CancelChannel = "JobCancel"
+
+// JobTTL is how long a job and its result are kept in redis.
+const JobTTL = 24 * time.Hour
+
+var ErrJobNotFound = errors.New("job not found")
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+type Job struct {
+	ID         string                  `json:"id"`
+	OwnerId    string                  `json:"ownerId"`
+	Status     Status                  `json:"status"`
+	Request    docker.RunRequest       `json:"request"`
+	Result     *docker.ExecutionResult `json:"result,omitempty"`
+	Error      string                  `json:"error,omitempty"`
+	CreatedAt  time.Time               `json:"createdAt"`
+	StartedAt  *time.Time              `json:"startedAt,omitempty"`
+	FinishedAt *time.Time              `json:"finishedAt,omitempty"`
+}
+
+// Queue stores jobs in redis and hands them out to workers in FIFO order.
+type Queue struct {
+	rds *redis.Client
+}
+
+func NewQueue(rds *redis.Client) *Queue {
+	return &Queue{rds}
+}
+
+func jobKey(id string) string {
+	return r.CacheKey{Entity: r.JobEntity, Identifier: id}.String()
+}
+
+// Enqueue saves a new job for rr and pushes it onto the queue.
+func (q *Queue) Enqueue(ctx context.Context, ownerId string, rr docker.RunRequest) (*Job, error) {
+	job := &Job{
+		ID:        uuid.NewString(),
+		OwnerId:   ownerId,
+		Status:    StatusQueued,
+		Request:   rr,
+		CreatedAt: time.Now(),
+	}
+	// the job ID doubles as the run ID, so the run can be cancelled with it
+	job.Request.ID = job.ID
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	// saved and pushed in one transaction, so no worker pops a job that isn't
+	// saved yet and no job is saved without being queued
+	_, err = q.rds.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, jobKey(job.ID), data, JobTTL)
+		pipe.LPush(ctx, QueueKey, job.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (q *Queue) Save(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return q.rds.Set(ctx, jobKey(job.ID), data, JobTTL).Err()
+}
+
+func (q *Queue) Get(ctx context.Context, id string) (*Job, error) {
+	data, err := q.rds.Get(ctx, jobKey(id)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Dequeue blocks for up to timeout waiting for the next job. It returns
+// ErrJobNotFound when nothing was queued in that time.
+func (q *Queue) Dequeue(ctx context.Context, timeout time.Duration) (*Job, error) {
+	res, err := q.rds.BRPop(ctx, timeout, QueueKey).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+
+	// res is [key, value]
+	return q.Get(ctx, res[1])
+}
+
+// Cancel marks a queued job as cancelled so no worker picks it up, and tells the
+// workers to stop it in case it is already running.
+func (q *Queue) Cancel(ctx context.Context, job *Job) error {
+	if job.Status == StatusQueued {
+		now := time.Now()
+		job.Status = StatusCanceled
+		job.FinishedAt = &now
+		if err := q.Save(ctx, job); err != nil {
+			return err
+		}
+	}
+
+	return q.rds.Publish(ctx, CancelChannel, job.ID).Err()
+}
+
+// SubscribeCancellations calls cancel with the ID of every job cancelled through
+// Cancel until ctx is done.
+func (q *Queue) SubscribeCancellations(ctx context.Context, cancel func(id string)) {
+	sub := q.rds.Subscribe(ctx, CancelChannel)
+	defer func() {
+		_ = sub.Close()
+	}()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			cancel(msg.Payload)
+		}
+	}
+}
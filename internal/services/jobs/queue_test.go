@@ -0,0 +1,194 @@
+package jobs
+
+import (
+	"code-garden-server/internal/services/docker"
+	"code-garden-server/internal/services/docker/fake"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMain(m *testing.M) {
+	err := docker.SetLanguages([]docker.LanguageSpec{{
+		Name:       "python",
+		Image:      "code-garden-python",
+		Dockerfile: "Dockerfile_python",
+		SourceFile: "file.py",
+		RunCommand: "python3 file.py",
+	}})
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func newTestQueue(t *testing.T) (*Queue, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	return NewQueue(redis.NewClient(&redis.Options{Addr: mr.Addr()})), mr
+}
+
+var testRequest = docker.RunRequest{Language: "python", Code: "print(input())", Stdin: "hi"}
+
+func TestEnqueue(t *testing.T) {
+	q, mr := newTestQueue(t)
+	ctx := context.Background()
+
+	job, err := q.Enqueue(ctx, "owner", testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusQueued || job.OwnerId != "owner" {
+		t.Errorf("got job with status %s and owner %q", job.Status, job.OwnerId)
+	}
+	if job.Request.ID != job.ID {
+		t.Errorf("run id %q isn't the job id %q", job.Request.ID, job.ID)
+	}
+
+	queued, err := mr.List(QueueKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0] != job.ID {
+		t.Errorf("got queue %v", queued)
+	}
+
+	saved, err := q.Get(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != StatusQueued || saved.Request.Code != testRequest.Code {
+		t.Errorf("got saved job %+v", saved)
+	}
+	if ttl := mr.TTL(jobKey(job.ID)); ttl != JobTTL {
+		t.Errorf("job expires in %v, want %v", ttl, JobTTL)
+	}
+}
+
+func TestGetUnknownJob(t *testing.T) {
+	q, _ := newTestQueue(t)
+	if _, err := q.Get(context.Background(), "nope"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrJobNotFound)
+	}
+}
+
+func TestDequeueInOrder(t *testing.T) {
+	q, _ := newTestQueue(t)
+	ctx := context.Background()
+
+	first, _ := q.Enqueue(ctx, "owner", testRequest)
+	second, _ := q.Enqueue(ctx, "owner", testRequest)
+
+	for _, want := range []*Job{first, second} {
+		job, err := q.Dequeue(ctx, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.ID != want.ID {
+			t.Errorf("dequeued %s, want %s", job.ID, want.ID)
+		}
+	}
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	q, _ := newTestQueue(t)
+	ctx := context.Background()
+
+	job, _ := q.Enqueue(ctx, "owner", testRequest)
+	if err := q.Cancel(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, _ := q.Get(ctx, job.ID)
+	if saved.Status != StatusCanceled || saved.FinishedAt == nil {
+		t.Errorf("got status %s, finished at %v", saved.Status, saved.FinishedAt)
+	}
+
+	// a worker skips the cancelled job
+	runner := fake.NewRunner()
+	w := NewWorker(q, runner)
+	dequeued, _ := q.Dequeue(ctx, time.Second)
+	w.process(ctx, dequeued)
+	if len(runner.Requests()) != 0 {
+		t.Error("the cancelled job was run")
+	}
+}
+
+func TestWorkerProcess(t *testing.T) {
+	q, _ := newTestQueue(t)
+	ctx := context.Background()
+
+	runner := fake.NewRunner()
+	w := NewWorker(q, runner)
+
+	job, _ := q.Enqueue(ctx, "owner", testRequest)
+	dequeued, _ := q.Dequeue(ctx, time.Second)
+	w.process(ctx, dequeued)
+
+	saved, _ := q.Get(ctx, job.ID)
+	if saved.Status != StatusCompleted {
+		t.Fatalf("got status %s: %s", saved.Status, saved.Error)
+	}
+	if saved.Result == nil || saved.Result.Stdout != "hi" {
+		t.Errorf("got result %+v", saved.Result)
+	}
+	if saved.StartedAt == nil || saved.FinishedAt == nil {
+		t.Error("job has no start or finish time")
+	}
+	if requests := runner.Requests(); len(requests) != 1 || requests[0].ID != job.ID {
+		t.Errorf("got run requests %+v", requests)
+	}
+}
+
+func TestWorkerProcessFailure(t *testing.T) {
+	q, _ := newTestQueue(t)
+	ctx := context.Background()
+
+	w := NewWorker(q, fake.NewRunner(fake.Response{Err: errors.New("docker is down")}))
+
+	job, _ := q.Enqueue(ctx, "owner", testRequest)
+	dequeued, _ := q.Dequeue(ctx, time.Second)
+	w.process(ctx, dequeued)
+
+	saved, _ := q.Get(ctx, job.ID)
+	if saved.Status != StatusFailed || saved.Error != "docker is down" {
+		t.Errorf("got status %s with error %q", saved.Status, saved.Error)
+	}
+}
+
+func TestQueueRunner(t *testing.T) {
+	q, _ := newTestQueue(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go NewWorker(q, fake.NewRunner()).Run(ctx)
+
+	rr := testRequest
+	rr.ID = "run-1"
+	result, err := NewQueueRunner(q).RunLanguageContainer(ctx, rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stdout != "hi" || result.RunID != "run-1" {
+		t.Errorf("got result %+v", result)
+	}
+}
+
+func TestQueueRunnerFailure(t *testing.T) {
+	q, _ := newTestQueue(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	unavailable := fake.NewRunner()
+	unavailable.BuildErrors["python"] = errors.New("build failed")
+	go NewWorker(q, unavailable).Run(ctx)
+
+	_, err := NewQueueRunner(q).RunLanguageContainer(ctx, testRequest)
+	if !errors.Is(err, docker.ErrLanguageUnavailable) {
+		t.Errorf("got error %v, want %v", err, docker.ErrLanguageUnavailable)
+	}
+}
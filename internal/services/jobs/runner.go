@@ -0,0 +1,199 @@
+package jobs
+
+import (
+	"code-garden-server/internal/services/docker"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ErrNotOnQueueRunner is returned for the parts of docker.Runner that only the
+// workers can do, like managing images and containers.
+var ErrNotOnQueueRunner = errors.New("not available when runs go through the job queue")
+
+// PollInterval is how often QueueRunner checks whether a job has finished.
+const PollInterval = 200 * time.Millisecond
+
+// QueueRunner is the docker.Runner of an API that leaves running code to the
+// workers. Runs are queued as jobs and waited for, so the API needs no docker
+// at all.
+type QueueRunner struct {
+	queue *Queue
+	// runJobs maps the ID of every run in progress to the ID of its job
+	runJobs sync.Map
+}
+
+func NewQueueRunner(queue *Queue) *QueueRunner {
+	return &QueueRunner{queue: queue}
+}
+
+var _ docker.Runner = (*QueueRunner)(nil)
+
+// RunLanguageContainer queues rr and waits for a worker to run it. The job is
+// cancelled when ctx is done before it finished.
+func (qr *QueueRunner) RunLanguageContainer(ctx context.Context, rr docker.RunRequest) (*docker.ExecutionResult, error) {
+	if _, ok := docker.GetLanguage(rr.Language); !ok {
+		return nil, docker.ErrUnsupportedLanguage
+	}
+	if err := rr.Validate(); err != nil {
+		return nil, err
+	}
+
+	if rr.ID != "" {
+		// claim the ID before queueing, its job ID is filled in below
+		if _, loaded := qr.runJobs.LoadOrStore(rr.ID, ""); loaded {
+			return nil, docker.ErrRunExists
+		}
+		defer qr.runJobs.Delete(rr.ID)
+	}
+
+	job, err := qr.queue.Enqueue(ctx, "", rr)
+	if err != nil {
+		return nil, err
+	}
+	if rr.ID != "" {
+		qr.runJobs.Store(rr.ID, job.ID)
+	}
+
+	job, err = qr.wait(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	switch job.Status {
+	case StatusFailed:
+		return nil, jobError(job)
+	case StatusCanceled:
+		if job.Result == nil {
+			return &docker.ExecutionResult{RunID: rr.ID, Canceled: true}, nil
+		}
+	}
+
+	job.Result.RunID = rr.ID
+	return job.Result, nil
+}
+
+// wait polls the job until it finished. When ctx is done first the job is
+// cancelled and ctx's error returned.
+func (qr *QueueRunner) wait(ctx context.Context, job *Job) (*Job, error) {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// cancel with a fresh context, ctx is already done
+			cancelCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if current, err := qr.queue.Get(cancelCtx, job.ID); err == nil {
+				job = current
+			}
+			_ = qr.queue.Cancel(cancelCtx, job)
+			return nil, ctx.Err()
+		case <-ticker.C:
+			current, err := qr.queue.Get(ctx, job.ID)
+			if err != nil {
+				if ctx.Err() != nil {
+					continue
+				}
+				return nil, err
+			}
+			if current.Status != StatusQueued && current.Status != StatusRunning {
+				return current, nil
+			}
+		}
+	}
+}
+
+// jobError turns the error a worker saved on a failed job back into an error,
+// keeping the docker errors the handlers respond to.
+func jobError(job *Job) error {
+	for _, known := range []error{
+		docker.ErrUnsupportedLanguage,
+		docker.ErrInvalidRunRequest,
+		docker.ErrLanguageUnavailable,
+		docker.ErrRunExists,
+	} {
+		if strings.HasPrefix(job.Error, known.Error()) {
+			return fmt.Errorf("%w%s", known, strings.TrimPrefix(job.Error, known.Error()))
+		}
+	}
+	return errors.New(job.Error)
+}
+
+// RunLanguageContainerStream runs rr like RunLanguageContainer. Workers don't
+// stream the output back, so it is emitted all at once after the run.
+func (qr *QueueRunner) RunLanguageContainerStream(ctx context.Context, rr docker.RunRequest, emit func(docker.StreamEvent)) error {
+	emit(docker.StreamEvent{Type: docker.StartEvent, RunID: rr.ID})
+
+	result, err := qr.RunLanguageContainer(ctx, rr)
+	if err != nil {
+		return err
+	}
+
+	if result.Stdout != "" {
+		emit(docker.StreamEvent{Type: docker.StdoutEvent, Data: result.Stdout})
+	}
+	if result.Stderr != "" {
+		emit(docker.StreamEvent{Type: docker.StderrEvent, Data: result.Stderr})
+	}
+	emit(docker.StreamEvent{
+		Type:          docker.ExitEvent,
+		RunID:         result.RunID,
+		StatusCode:    result.ExitCode,
+		Duration:      result.WallTime,
+		Phase:         result.Phase,
+		TimedOut:      result.TimedOut,
+		Canceled:      result.Canceled,
+		OOMKilled:     result.OOMKilled,
+		LimitExceeded: result.LimitExceeded,
+	})
+	return nil
+}
+
+// Cancel cancels the job of the run with runID.
+func (qr *QueueRunner) Cancel(runID string) bool {
+	jobID, ok := qr.runJobs.Load(runID)
+	if !ok || jobID == "" {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	job, err := qr.queue.Get(ctx, jobID.(string))
+	if err != nil {
+		return false
+	}
+	return qr.queue.Cancel(ctx, job) == nil
+}
+
+func (qr *QueueRunner) ListRunningContainers() ([]types.Container, error) {
+	return nil, ErrNotOnQueueRunner
+}
+
+func (qr *QueueRunner) BuildLanguageImage(docker.Language) error {
+	return ErrNotOnQueueRunner
+}
+
+// BuildStatuses is empty, images are built by the workers.
+func (qr *QueueRunner) BuildStatuses() []docker.BuildStatus {
+	return []docker.BuildStatus{}
+}
+
+// IsLanguageAvailable reports whether the language is supported at all. Whether
+// its image is ready is up to the workers, which fail the job if it isn't.
+func (qr *QueueRunner) IsLanguageAvailable(lang docker.Language) bool {
+	_, ok := docker.GetLanguage(lang)
+	return ok
+}
+
+// PoolStats is empty, the container pools live in the workers.
+func (qr *QueueRunner) PoolStats() []docker.PoolStats {
+	return []docker.PoolStats{}
+}
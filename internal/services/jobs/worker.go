@@ -0,0 +1,102 @@
+package jobs
+
+import (
+	"code-garden-server/config"
+	"code-garden-server/internal/services/docker"
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultWorkerConcurrency is the number of jobs a worker runs at once when
+// WORKER_CONCURRENCY is not set.
+const DefaultWorkerConcurrency = 4
+
+// Worker pulls jobs off the queue and runs them with a runner.
+type Worker struct {
+	queue       *Queue
+	service     docker.Runner
+	concurrency int
+}
+
+func NewWorker(queue *Queue, service docker.Runner) *Worker {
+	concurrency := DefaultWorkerConcurrency
+	if v, err := strconv.Atoi(config.GetEnv("WORKER_CONCURRENCY")); err == nil && v > 0 {
+		concurrency = v
+	}
+	return &Worker{queue, service, concurrency}
+}
+
+// Run processes jobs until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	log.Printf("worker started with concurrency %d", w.concurrency)
+
+	go w.queue.SubscribeCancellations(ctx, func(id string) {
+		if w.service.Cancel(id) {
+			log.Printf("cancelled job %s", id)
+		}
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *Worker) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := w.queue.Dequeue(ctx, 5*time.Second)
+		if err != nil {
+			if !errors.Is(err, ErrJobNotFound) && ctx.Err() == nil {
+				log.Printf("failed to dequeue job: %v", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		w.process(ctx, job)
+	}
+}
+
+func (w *Worker) process(ctx context.Context, job *Job) {
+	if job.Status == StatusCanceled {
+		return
+	}
+
+	now := time.Now()
+	job.Status = StatusRunning
+	job.StartedAt = &now
+	if err := w.queue.Save(ctx, job); err != nil {
+		log.Printf("failed to mark job %s as running: %v", job.ID, err)
+	}
+
+	result, err := w.service.RunLanguageContainer(ctx, job.Request)
+
+	finished := time.Now()
+	job.FinishedAt = &finished
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	} else if result.Canceled {
+		job.Status = StatusCanceled
+		job.Result = result
+	} else {
+		job.Status = StatusCompleted
+		job.Result = result
+	}
+
+	// save with a fresh context so results aren't lost while shutting down
+	saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.queue.Save(saveCtx, job); err != nil {
+		log.Printf("failed to save result of job %s: %v", job.ID, err)
+	}
+}
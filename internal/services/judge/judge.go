@@ -0,0 +1,153 @@
+package judge
+
+import (
+	"code-garden-server/internal/database/models"
+	"code-garden-server/internal/services/docker"
+	"code-garden-server/utils"
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// MaxTestCases is the maximum number of test cases a snippet can have. Each case
+// is a run, so it's kept within the runs a user may start per minute.
+const MaxTestCases = 20
+
+type Verdict string
+
+const (
+	Accepted          Verdict = "accepted"
+	WrongAnswer       Verdict = "wrong_answer"
+	RuntimeError      Verdict = "runtime_error"
+	TimeLimitExceeded Verdict = "time_limit_exceeded"
+	CompileError      Verdict = "compile_error"
+)
+
+// Submission is the program being judged, either Code or a multi-file project.
+type Submission struct {
+	Language   docker.Language
+	Code       string
+	Args       []string
+	Files      map[string]string
+	Entrypoint string
+}
+
+// CaseResult is the outcome of running a submission against one test case.
+// The output and the diff are left out for hidden cases unless they are revealed.
+type CaseResult struct {
+	TestCaseId    uuid.UUID `json:"testCaseId"`
+	Name          string    `json:"name"`
+	Position      int       `json:"position"`
+	Hidden        bool      `json:"hidden"`
+	Verdict       Verdict   `json:"verdict"`
+	Stdin         string    `json:"stdin,omitempty"`
+	Stdout        string    `json:"stdout,omitempty"`
+	Stderr        string    `json:"stderr,omitempty"`
+	ExitCode      int       `json:"exitCode"`
+	RunTime       int64     `json:"runTime"` // milliseconds
+	LimitExceeded string    `json:"limitExceeded,omitempty"`
+	Diff          string    `json:"diff,omitempty"`
+}
+
+// Report is the outcome of judging a submission. Its Verdict is Accepted when
+// every case passed, otherwise the verdict of the first failing case.
+type Report struct {
+	Verdict Verdict      `json:"verdict"`
+	Passed  int          `json:"passed"`
+	Total   int          `json:"total"`
+	Cases   []CaseResult `json:"cases"`
+	// Ran is how many times the submission was handed to the runner, which is
+	// less than Total after a compile error
+	Ran int `json:"-"`
+}
+
+// Run runs the submission once per test case, in order. A compile error fails
+// every remaining case without running it again. The returned error is only set
+// when the runner itself failed; failing programs are reported as verdicts. The
+// report is returned with the error too, so callers can tell how many cases ran.
+func Run(ctx context.Context, runner docker.Runner, sub Submission, testCases []models.TestCase, revealHidden bool) (*Report, error) {
+	report := &Report{Verdict: Accepted, Total: len(testCases), Cases: make([]CaseResult, 0, len(testCases))}
+
+	var compileError *docker.ExecutionResult
+	for _, tc := range testCases {
+		res := compileError
+		if res == nil {
+			var err error
+			report.Ran++
+			res, err = runner.RunLanguageContainer(ctx, docker.RunRequest{
+				ID:          uuid.NewString(),
+				Language:    sub.Language,
+				Code:        sub.Code,
+				Stdin:       tc.Stdin,
+				Args:        sub.Args,
+				Files:       sub.Files,
+				Entrypoint:  sub.Entrypoint,
+				TimeLimitMs: tc.TimeLimitMs,
+			})
+			if err != nil {
+				return report, err
+			}
+			if res.Canceled {
+				return report, context.Cause(ctx)
+			}
+		}
+
+		cr := CaseResult{
+			TestCaseId:    tc.ID,
+			Name:          tc.Name,
+			Position:      tc.Position,
+			Hidden:        tc.Hidden,
+			Verdict:       verdict(tc, res),
+			Stdin:         tc.Stdin,
+			Stdout:        res.Stdout,
+			Stderr:        res.Stderr,
+			ExitCode:      res.ExitCode,
+			RunTime:       res.RunTime,
+			LimitExceeded: res.LimitExceeded,
+		}
+		if cr.Verdict == WrongAnswer {
+			// outputs too different to diff are left for the client to compare
+			cr.Diff, _ = utils.UnifiedDiff("expected", "actual", normalizeOutput(tc.ExpectedStdout), normalizeOutput(res.Stdout))
+		}
+		if cr.Verdict == CompileError {
+			compileError = res
+		}
+		if tc.Hidden && !revealHidden {
+			cr.Stdin, cr.Stdout, cr.Stderr, cr.Diff = "", "", "", ""
+		}
+
+		if cr.Verdict == Accepted {
+			report.Passed++
+		} else if report.Verdict == Accepted {
+			report.Verdict = cr.Verdict
+		}
+		report.Cases = append(report.Cases, cr)
+	}
+
+	return report, nil
+}
+
+func verdict(tc models.TestCase, res *docker.ExecutionResult) Verdict {
+	switch {
+	case res.Phase == docker.CompilePhase:
+		return CompileError
+	case res.TimedOut, tc.TimeLimitMs > 0 && res.RunTime > tc.TimeLimitMs:
+		return TimeLimitExceeded
+	case res.ExitCode != 0, res.OOMKilled:
+		return RuntimeError
+	case normalizeOutput(res.Stdout) != normalizeOutput(tc.ExpectedStdout):
+		return WrongAnswer
+	}
+	return Accepted
+}
+
+// normalizeOutput ignores line endings, trailing spaces and trailing blank lines
+// so they don't turn an otherwise correct answer into a wrong one.
+func normalizeOutput(s string) string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
@@ -0,0 +1,139 @@
+package trash
+
+import (
+	"code-garden-server/config"
+	"code-garden-server/internal/database"
+	"code-garden-server/internal/database/models"
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DefaultRetentionDays is how long deleted snippets stay in the trash when
+// TRASH_RETENTION_DAYS is not set.
+const DefaultRetentionDays = 30
+
+// PurgeInterval is how often the purger looks for snippets to purge.
+const PurgeInterval = time.Hour
+
+// Retention returns how long deleted snippets stay in the trash before they're purged.
+func Retention() time.Duration {
+	days := DefaultRetentionDays
+	if v, err := strconv.Atoi(config.GetEnv("TRASH_RETENTION_DAYS")); err == nil && v > 0 {
+		days = v
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// PurgeSnippet permanently deletes a snippet and everything that belongs to it.
+// Forks of the snippet are kept, they just no longer point at it. When the
+// snippet is itself a fork it stops counting towards its parent's forks; it
+// counted while in the trash since it could still be restored.
+func PurgeSnippet(tx *gorm.DB, snippetId uuid.UUID) error {
+	// a new session, so every statement below starts from a clean unscoped one
+	tx = tx.Unscoped().Session(&gorm.Session{})
+
+	var snippet models.Snippet
+	if err := tx.Select("id", "forked_from_id").First(&snippet, "id = ?", snippetId).Error; err != nil {
+		return err
+	}
+	if snippet.ForkedFromId != nil {
+		if err := tx.Model(&models.Snippet{}).Where("id = ?", *snippet.ForkedFromId).
+			UpdateColumn("forks", gorm.Expr("GREATEST(forks - 1, 0)")).Error; err != nil {
+			return err
+		}
+	}
+
+	proposals := tx.Model(&models.SnippetProposal{}).Select("id").Where("target_id = ? OR source_id = ?", snippetId, snippetId)
+	if err := tx.Where("proposal_id IN (?)", proposals).Delete(&models.ProposalComment{}).Error; err != nil {
+		return err
+	}
+
+	id := sql.Named("id", snippetId)
+	deletes := []struct {
+		model interface{}
+		query string
+	}{
+		{&models.SnippetProposal{}, "target_id = @id OR source_id = @id"},
+		{&models.Comment{}, "snippet_id = @id"},
+		{&models.TestCase{}, "snippet_id = @id"},
+		{&models.SnippetFile{}, "snippet_id = @id"},
+		{&models.SnippetRevision{}, "snippet_id = @id"},
+		{&models.Star{}, "snippet_id = @id"},
+		{&models.CollectionSnippet{}, "snippet_id = @id"},
+		{&models.SnippetTransfer{}, "snippet_id = @id"},
+	}
+	for _, d := range deletes {
+		if err := tx.Where(d.query, id).Delete(d.model).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Exec("DELETE FROM snippet_tags WHERE snippet_id = ?", snippetId).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&models.Snippet{}, "id = ?", snippetId).Error
+}
+
+// Purger permanently deletes snippets that have been in the trash for longer
+// than the retention period.
+type Purger struct {
+	db        *database.DBClient
+	retention time.Duration
+}
+
+func NewPurger(db *database.DBClient) *Purger {
+	return &Purger{db, Retention()}
+}
+
+// Run purges the trash every PurgeInterval until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	log.Printf("purging snippets deleted more than %s ago", p.retention)
+
+	ticker := time.NewTicker(PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := p.Purge(); err != nil {
+			log.Printf("failed to purge the trash: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d snippets from the trash", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge permanently deletes the snippets whose retention period is over and
+// returns how many it deleted.
+func (p *Purger) Purge() (int, error) {
+	var ids []uuid.UUID
+	tx := p.db.Unscoped().Model(&models.Snippet{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", time.Now().Add(-p.retention)).
+		Pluck("id", &ids)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	// every snippet is purged on its own so one failure doesn't hold up the rest
+	purged := 0
+	for _, id := range ids {
+		if err := p.db.Transaction(func(tx *gorm.DB) error {
+			return PurgeSnippet(tx, id)
+		}); err != nil {
+			log.Printf("failed to purge snippet %s: %v", id, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -1,21 +1,28 @@
 package main
 
 import (
+	"code-garden-server/config"
 	"code-garden-server/internal/api"
 	"code-garden-server/internal/database"
 	"code-garden-server/internal/database/redis"
 	"code-garden-server/internal/services/docker"
+	"code-garden-server/internal/services/jobs"
+	"code-garden-server/internal/services/trash"
+	"context"
 	"log"
+	"os"
+
+	"github.com/docker/docker/client"
 )
 
 func main() {
-	dckClient, err := docker.NewDockerClient()
-	if err != nil {
+	languagesFile := config.GetEnv("LANGUAGES_FILE")
+	if languagesFile == "" {
+		languagesFile = docker.DefaultLanguagesFile
+	}
+	if err := docker.LoadLanguages(languagesFile); err != nil {
 		log.Fatal(err)
 	}
-	defer func() {
-		_ = dckClient.Close()
-	}()
 
 	// create a new database client
 	dbClient, err := database.NewDBClient()
@@ -45,7 +52,49 @@ func main() {
 		log.Fatal("failed to setup database", err)
 	}
 
+	// `./main worker` runs queued code runner jobs instead of serving the API
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		log.Println("starting worker")
+		dckClient := newDockerClient()
+		defer func() {
+			_ = dckClient.Close()
+		}()
+
+		service := docker.NewDockerService(dckClient, dbClient, "worker")
+		jobs.NewWorker(jobs.NewQueue(redisClient), service).Run(context.Background())
+		return
+	}
+
+	// with RUNNER=queue the API queues every run for the workers and doesn't
+	// touch docker itself
+	var runner docker.Runner
+	if config.GetEnv("RUNNER") == "queue" {
+		if redisClient == nil {
+			log.Fatal("RUNNER=queue needs redis")
+		}
+		log.Println("running code through the job queue")
+		runner = jobs.NewQueueRunner(jobs.NewQueue(redisClient))
+	} else {
+		dckClient := newDockerClient()
+		defer func() {
+			_ = dckClient.Close()
+		}()
+
+		runner = docker.NewDockerService(dckClient, dbClient, "api")
+	}
+
+	// trashed snippets are purged by the server, after their retention period
+	go trash.NewPurger(dbClient).Run(context.Background())
+
 	PORT := 3000
 	log.Printf("starting server on port %d", PORT)
-	api.InitServer(PORT, dckClient, dbClient, redisClient)
+	api.InitServer(PORT, runner, dbClient, redisClient)
+}
+
+func newDockerClient() *client.Client {
+	dckClient, err := docker.NewDockerClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return dckClient
 }
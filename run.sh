@@ -1,55 +1,46 @@
 #!/bin/sh
 
-# Check if a language argument is provided
-if [ -z "$1" ]; then
-    echo "Error: No language specified."
-    echo "Usage: $0 <language>"
+# The server copies the source, args and env of the program into this directory,
+# along with a manifest setting FILE, COMPILE_CMD and RUN_CMD for the language.
+# Multi-file projects come as a project/ directory instead of the source, and
+# the manifest sets PROJECT and ENTRYPOINT instead of FILE.
+INPUT_DIR=".code-garden"
+
+# Markers written to stderr when switching phases, the server strips them from the output
+PHASE_MARKER="::code-garden-phase::"
+
+if [ ! -f "$INPUT_DIR/manifest" ]; then
+    echo "Error: No run manifest found."
     exit 1
 fi
 
-LANGUAGE="$1"
-
-# Determine the file extension and command based on the language
-case "$LANGUAGE" in
-    go)
-        FILE="file.go"
-        RUN_CMD="go run $FILE"
-        ;;
-    python)
-        FILE="file.py"
-        RUN_CMD="python3 $FILE"
-        ;;
-    rust)
-        FILE="file.rs"
-        RUN_CMD="rustc $FILE && ./file"
-        ;;
-    cpp)
-        FILE="file.cpp"
-        RUN_CMD="g++ $FILE && ./a.out"
-        ;;
-    swift)
-        FILE="file.swift"
-        RUN_CMD="swift $FILE"
-        ;;
-    ruby)
-        FILE="file.rb"
-        RUN_CMD="ruby $FILE"
-        ;;
-    node)
-        FILE="file.js"
-        RUN_CMD="node $FILE"
-        ;;
-    *)
-        echo "Error: Unsupported language '$LANGUAGE'."
-        exit 1
-        ;;
-esac
-
-# Read the input source code and write to the appropriate file
-cat > "$FILE"
+. "./$INPUT_DIR/manifest"
+
+# Copy the source code to the appropriate file, or the project's files to the work dir
+if [ -n "$PROJECT" ]; then
+    cp -R "$INPUT_DIR/project/." .
+else
+    cp "$INPUT_DIR/source" "$FILE"
+fi
+
+# Compile the code first for compiled languages, stdin is left for the program
+if [ -n "$COMPILE_CMD" ]; then
+    echo "${PHASE_MARKER}compile" >&2
+    eval "$COMPILE_CMD" < /dev/null || exit $?
+fi
+
+# Set the program's own arguments and export its environment
+. "./$INPUT_DIR/args"
+. "./$INPUT_DIR/env"
 
 # Run the code using the specified command
-eval "$RUN_CMD"
+echo "${PHASE_MARKER}run" >&2
+eval "$RUN_CMD \"\$@\""
+STATUS=$?
 
 # Delete the source code file after execution
-rm "$FILE"
+if [ -z "$PROJECT" ]; then
+    rm "$FILE"
+fi
+
+exit $STATUS
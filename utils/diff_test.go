@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	got, err := UnifiedDiff("a", "b", "one\ntwo\nthree\n", "one\n2\nthree\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- a\n+++ b\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"
+	if got != want {
+		t.Errorf("got diff\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnifiedDiffEqual(t *testing.T) {
+	if got, err := UnifiedDiff("a", "b", "same\n", "same\n"); got != "" || err != nil {
+		t.Errorf("got diff %q and error %v for equal inputs", got, err)
+	}
+}
+
+func TestUnifiedDiffNoNewlineAtEnd(t *testing.T) {
+	got, err := UnifiedDiff("a", "b", "a\n", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- a\n+++ b\n@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n"
+	if got != want {
+		t.Errorf("got diff\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnifiedDiffTooLarge(t *testing.T) {
+	// every line differs, so the whole of both sides needs the LCS table
+	lines := func(prefix string, n int) string {
+		var sb strings.Builder
+		for i := 0; i < n; i++ {
+			sb.WriteString(prefix)
+			sb.WriteString(strings.Repeat("x", i%7))
+			sb.WriteByte('\n')
+		}
+		return sb.String()
+	}
+
+	if _, err := UnifiedDiff("a", "b", lines("a", 2000), lines("b", 2000)); err != nil {
+		t.Errorf("diff within the bound failed: %v", err)
+	}
+	if _, err := UnifiedDiff("a", "b", lines("a", 2001), lines("b", 2000)); !errors.Is(err, ErrDiffTooLarge) {
+		t.Errorf("got error %v, want %v", err, ErrDiffTooLarge)
+	}
+}
@@ -3,6 +3,8 @@ package utils
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"strings"
+	"unicode"
 )
 
 func GenerateRandomString(length int) (string, error) {
@@ -13,3 +15,22 @@ func GenerateRandomString(length int) (string, error) {
 	}
 	return base64.URLEncoding.EncodeToString(b), nil
 }
+
+// Slugify lowercases s and joins its runs of letters and digits with dashes,
+// e.g. "Dynamic Programming!" becomes "dynamic-programming".
+func Slugify(s string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return sb.String()
+}
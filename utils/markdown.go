@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"bytes"
+	"html"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdown renders GitHub flavored markdown. Raw HTML and links with script
+// schemes are left out since goldmark is not configured to render them.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// markdownPolicy cleans up whatever HTML the markdown renders to, so the result
+// is safe even if the renderer lets something through.
+var markdownPolicy = bluemonday.UGCPolicy()
+
+// RenderMarkdown renders user written markdown to HTML that is safe to show in
+// a page. The markdown itself is stored as it was written.
+func RenderMarkdown(s string) string {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(s), &buf); err != nil {
+		// the markdown still shows up as text
+		return html.EscapeString(s)
+	}
+	return string(markdownPolicy.SanitizeBytes(buf.Bytes()))
+}